@@ -4,8 +4,10 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/w0ikid/megacalc/internal/agent"
+	"github.com/w0ikid/megacalc/internal/service"
 )
 
 func main() {
@@ -28,9 +30,39 @@ func main() {
 		}
 	}
 	
+	// Get the supported operations and their affinities from environment variables,
+	// e.g. AGENT_OPERATIONS=+,- and AGENT_AFFINITY=sqrt:10,^:5
+	ops, err := service.ParseOperations(splitList(os.Getenv("AGENT_OPERATIONS")))
+	if err != nil {
+		log.Fatalf("Invalid AGENT_OPERATIONS: %v", err)
+	}
+	affinity, err := service.ParseAffinity(splitList(os.Getenv("AGENT_AFFINITY")))
+	if err != nil {
+		log.Fatalf("Invalid AGENT_AFFINITY: %v", err)
+	}
+	
 	// Create agent
-	a := agent.NewAgent(orchestratorURL, computingPower)
+	a := agent.NewAgent(orchestratorURL, computingPower, agent.WithOperations(ops), agent.WithAffinity(affinity))
 	
-	// Start agent
-	a.Start()
-}
\ No newline at end of file
+	// Start agent with the transport chosen by AGENT_TRANSPORT
+	switch transport := os.Getenv("AGENT_TRANSPORT"); transport {
+	case "grpc":
+		grpcAddr := os.Getenv("ORCHESTRATOR_GRPC_ADDR")
+		if grpcAddr == "" {
+			grpcAddr = "orchestrator:9090"
+		}
+		a.StartGRPC(grpcAddr)
+	case "", "http":
+		a.Start()
+	default:
+		log.Fatalf("Unknown AGENT_TRANSPORT: %s, expected http or grpc", transport)
+	}
+}
+
+// splitList splits a comma separated environment variable, an empty value gives an empty list
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"os"
+	"time"
 
 	"github.com/w0ikid/megacalc/internal/api"
+	"github.com/w0ikid/megacalc/internal/rpc"
 	"github.com/w0ikid/megacalc/internal/service"
 )
 
@@ -14,8 +18,38 @@ func main() {
 	// Get operation times from environment variables
 	opTimes := api.GetOperationTimes()
 	
+	// Get the default numeric mode from environment variables
+	mode, precision := api.GetNumericMode()
+	
+	// Open the store that keeps expressions across restarts
+	store, err := api.GetStore()
+	if err != nil {
+		log.Fatalf("Error opening store: %v", err)
+	}
+	defer store.Close()
+	
 	// Create service
-	svc := service.NewService(opTimes)
+	svc := service.NewService(opTimes,
+		service.WithNumericMode(mode, precision),
+		service.WithLeaseGrace(api.GetLeaseGrace()),
+		service.WithHeartbeatTimeout(api.GetHeartbeatTimeout()),
+		service.WithAgentRemoveAfter(api.GetAgentRemoveAfter()),
+		service.WithScheduler(api.GetScheduler()),
+		service.WithStore(store),
+		service.WithRetention(api.GetRetention()),
+		service.WithIdempotencyTTL(api.GetIdempotencyTTL()),
+	)
+	
+	// Load the expressions of the previous run and requeue their unfinished tasks
+	if err := svc.Recover(); err != nil {
+		log.Fatalf("Error recovering state: %v", err)
+	}
+	
+	// Return tasks of lost and dead agents to the queue
+	go svc.RunLeaseReaper(context.Background(), time.Second)
+	
+	// Remove finished expressions the retention policy no longer keeps
+	go svc.RunCollector(context.Background(), api.GetGCInterval())
 	
 	// Create handler
 	handler := api.NewHandler(svc)
@@ -26,10 +60,26 @@ func main() {
 		addr = ":8080"
 	}
 	
+	// Serve the gRPC task protocol alongside the HTTP API
+	grpcAddr := os.Getenv("ORCHESTRATOR_GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", grpcAddr, err)
+	}
+	go func() {
+		log.Printf("Task service listening on %s", grpcAddr)
+		if err := rpc.NewServer(svc).Serve(lis); err != nil {
+			log.Fatalf("Error serving task service: %v", err)
+		}
+	}()
+	
 	log.Printf("Orchestrator listening on %s", addr)
 	
 	// Start server
-	err := handler.Start(addr)
+	err = handler.Start(addr)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -3,49 +3,127 @@ package agent
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"net/url"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/w0ikid/megacalc/internal/service"
 )
 
+// Version of the agent reported on registration, set with -ldflags "-X github.com/w0ikid/megacalc/internal/agent.Version=..."
+var Version = "dev"
+
 // Agent represents a computational agent
 type Agent struct {
+	id              string
+	hostname        string
 	orchestratorURL string
 	computingPower  int
+	operations      []service.Operation
+	affinity        map[service.Operation]int
 	client          *http.Client
 }
 
+// Option configures optional settings of an Agent
+type Option func(*Agent)
+
+// WithOperations limits the agent to the given operations, by default it runs every supported one
+func WithOperations(ops []service.Operation) Option {
+	return func(a *Agent) {
+		if len(ops) > 0 {
+			a.operations = ops
+		}
+	}
+}
+
+// WithAffinity makes the orchestrator prefer tasks of operations with a higher weight
+func WithAffinity(affinity map[service.Operation]int) Option {
+	return func(a *Agent) {
+		a.affinity = affinity
+	}
+}
+
 // TaskResponse represents a task response from the orchestrator
 type TaskResponse struct {
 	Task *service.Task `json:"task,omitempty"`
 }
 
-// TaskResultRequest represents a request to set a task result
+// TaskResultRequest represents a request to set a task result. The result is
+// sent as a JSON string, so that exact values are never read as float64.
 type TaskResultRequest struct {
-	ID     string  `json:"id" binding:"required"`
-	Result float64 `json:"result" binding:"required"`
+	ID      string `json:"id" binding:"required"`
+	LeaseID string `json:"lease_id" binding:"required"`
+	Result  string `json:"result" binding:"required"`
+}
+
+// RegisterRequest represents the registration of the agent
+type RegisterRequest struct {
+	ID             string                    `json:"id"`
+	Hostname       string                    `json:"hostname"`
+	ComputingPower int                       `json:"computing_power"`
+	Operations     []service.Operation       `json:"operations"`
+	Affinity       map[service.Operation]int `json:"affinity,omitempty"`
+	Version        string                    `json:"version"`
 }
 
+// TaskErrorRequest represents a request to report that a task could not be computed
+type TaskErrorRequest struct {
+	ID      string `json:"id" binding:"required"`
+	LeaseID string `json:"lease_id" binding:"required"`
+	Code    string `json:"code" binding:"required"`
+	Message string `json:"message" binding:"required"`
+}
+
+// pollWait is how long the orchestrator holds a task request open when no task is ready
+const pollWait = 30 * time.Second
+
+// errDiscarded is returned when the orchestrator no longer wants a result,
+// e.g. because the expression was cancelled or the lease expired
+var errDiscarded = errors.New("result discarded by orchestrator")
+
+// heartbeatInterval is how often the agent tells the orchestrator that it is alive
+const heartbeatInterval = 5 * time.Second
+
 // NewAgent creates a new agent
-func NewAgent(orchestratorURL string, computingPower int) *Agent {
-	return &Agent{
+func NewAgent(orchestratorURL string, computingPower int, opts ...Option) *Agent {
+	hostname, _ := os.Hostname()
+	a := &Agent{
+		id:              uuid.New().String(),
+		hostname:        hostname,
 		orchestratorURL: orchestratorURL,
 		computingPower:  computingPower,
+		operations:      service.SupportedOperations(),
 		client: &http.Client{
-			Timeout: 5 * time.Second,
+			Timeout: pollWait + 5*time.Second,
 		},
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
 // Start starts the agent with the specified computing power
 func (a *Agent) Start() {
-	log.Printf("Starting agent with %d computing power", a.computingPower)
+	log.Printf("Starting agent %s with %d computing power", a.id, a.computingPower)
+	
+	// Register with the orchestrator and keep telling it that the agent is alive
+	for {
+		err := a.register()
+		if err == nil {
+			break
+		}
+		log.Printf("Error registering agent: %v, retrying in 1 second", err)
+		time.Sleep(1 * time.Second)
+	}
+	go a.heartbeats()
 	
 	var wg sync.WaitGroup
 	
@@ -61,6 +139,43 @@ func (a *Agent) Start() {
 	wg.Wait()
 }
 
+// registration describes the agent to the orchestrator
+func (a *Agent) registration() RegisterRequest {
+	return RegisterRequest{
+		ID:             a.id,
+		Hostname:       a.hostname,
+		ComputingPower: a.computingPower,
+		Operations:     a.operations,
+		Affinity:       a.affinity,
+		Version:        Version,
+	}
+}
+
+// register registers the agent with the orchestrator
+func (a *Agent) register() error {
+	url := fmt.Sprintf("%s/internal/agents", a.orchestratorURL)
+	return a.post(url, a.registration(), http.StatusCreated)
+}
+
+// heartbeats sends a heartbeat every heartbeatInterval, registering again
+// if the orchestrator does not know the agent, e.g. after a restart
+func (a *Agent) heartbeats() {
+	url := fmt.Sprintf("%s/internal/agents/%s/heartbeat", a.orchestratorURL, a.id)
+	
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		err := a.post(url, nil, http.StatusOK)
+		if err != nil {
+			log.Printf("Error sending heartbeat: %v, registering again", err)
+			if err := a.register(); err != nil {
+				log.Printf("Error registering agent: %v", err)
+			}
+		}
+	}
+}
+
 // worker is the main worker loop
 func (a *Agent) worker(id int) {
 	log.Printf("Worker %d started", id)
@@ -74,35 +189,53 @@ func (a *Agent) worker(id int) {
 			continue
 		}
 		
-		// No task available, wait a bit and try again
+		// No task became ready while waiting, ask again
 		if task == nil {
-			time.Sleep(500 * time.Millisecond)
 			continue
 		}
 		
-		log.Printf("Worker %d: Processing task %s: %s %s %s", id, task.ID, task.Arg1, task.Operation, task.Arg2)
+		log.Printf("Worker %d: Processing task %s: %s %v", id, task.ID, task.Operation, task.Args)
 		
 		// Process the task
 		result, err := a.processTask(task)
 		if err != nil {
 			log.Printf("Worker %d: Error processing task %s: %v", id, task.ID, err)
+			
+			// Report the failure, so that the expression does not wait forever
+			err = a.submitError(task, err)
+			if err != nil {
+				log.Printf("Worker %d: Error reporting failure of task %s: %v", id, task.ID, err)
+			}
 			continue
 		}
 		
 		// Submit the result
-		err = a.submitResult(task.ID, result)
+		err = a.submitResult(task, result)
+		if errors.Is(err, errDiscarded) {
+			log.Printf("Worker %d: Result for task %s discarded: %v", id, task.ID, err)
+			continue
+		}
 		if err != nil {
 			log.Printf("Worker %d: Error submitting result for task %s: %v", id, task.ID, err)
 			continue
 		}
 		
-		log.Printf("Worker %d: Completed task %s with result %.2f", id, task.ID, result)
+		log.Printf("Worker %d: Completed task %s with result %s", id, task.ID, result)
 	}
 }
 
 // getTask gets a task from the orchestrator
 func (a *Agent) getTask() (*service.Task, error) {
-	url := fmt.Sprintf("%s/internal/task", a.orchestratorURL)
+	query := url.Values{}
+	query.Set("wait", pollWait.String())
+	query.Set("agent_id", a.id)
+	for _, op := range a.operations {
+		query.Add("op", string(op))
+	}
+	for op, weight := range a.affinity {
+		query.Add("affinity", fmt.Sprintf("%s:%d", op, weight))
+	}
+	url := fmt.Sprintf("%s/internal/task?%s", a.orchestratorURL, query.Encode())
 	
 	resp, err := a.client.Get(url)
 	if err != nil {
@@ -128,33 +261,41 @@ func (a *Agent) getTask() (*service.Task, error) {
 	return taskResp.Task, nil
 }
 
-// processTask processes a task
-func (a *Agent) processTask(task *service.Task) (float64, error) {
-	// Parse the arguments
-	arg1, err := parseArg(task.Arg1)
-	if err != nil {
-		return 0, fmt.Errorf("invalid arg1: %v", err)
-	}
-	
-	arg2, err := parseArg(task.Arg2)
-	if err != nil {
-		return 0, fmt.Errorf("invalid arg2: %v", err)
-	}
-	
-	// Process the operation
-	return service.ProcessOperation(task.Operation, arg1, arg2, task.OperationTime)
+// processTask processes a task in its numeric mode
+func (a *Agent) processTask(task *service.Task) (service.Value, error) {
+	return service.ProcessTask(task)
 }
 
 // submitResult submits the result to the orchestrator
-func (a *Agent) submitResult(taskID string, result float64) error {
+func (a *Agent) submitResult(task *service.Task, result service.Value) error {
 	url := fmt.Sprintf("%s/internal/task", a.orchestratorURL)
 	
 	resultReq := TaskResultRequest{
-		ID:     taskID,
-		Result: result,
+		ID:      task.ID,
+		LeaseID: task.LeaseID,
+		Result:  string(result),
+	}
+	
+	return a.post(url, resultReq, http.StatusOK)
+}
+
+// submitError reports a task that could not be computed to the orchestrator
+func (a *Agent) submitError(task *service.Task, taskErr error) error {
+	url := fmt.Sprintf("%s/internal/task/error", a.orchestratorURL)
+	
+	errorReq := TaskErrorRequest{
+		ID:      task.ID,
+		LeaseID: task.LeaseID,
+		Code:    service.FailureCode(taskErr),
+		Message: taskErr.Error(),
 	}
 	
-	jsonData, err := json.Marshal(resultReq)
+	return a.post(url, errorReq, http.StatusOK)
+}
+
+// post sends a JSON request to the orchestrator and checks the response status
+func (a *Agent) post(url string, payload interface{}, status int) error {
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -171,15 +312,14 @@ func (a *Agent) submitResult(taskID string, result float64) error {
 	}
 	defer resp.Body.Close()
 	
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusConflict {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: %s", errDiscarded, body)
+	}
+	if resp.StatusCode != status {
 		body, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
 	}
 	
 	return nil
-}
-
-// parseArg parses an argument, which could be a task ID or a numerical value
-func parseArg(arg string) (float64, error) {
-	return strconv.ParseFloat(arg, 64)
 }
\ No newline at end of file
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/w0ikid/megacalc/internal/rpc"
+	"github.com/w0ikid/megacalc/internal/rpc/taskpb"
+	"github.com/w0ikid/megacalc/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// StartGRPC runs the agent over a TaskService stream instead of polling the HTTP API.
+// The agent grants the orchestrator one credit per worker, so it never receives
+// more tasks than it can compute at once.
+func (a *Agent) StartGRPC(addr string) {
+	log.Printf("Starting agent %s with %d computing power over gRPC", a.id, a.computingPower)
+
+	for {
+		err := a.session(addr)
+		log.Printf("Task stream ended: %v, reconnecting in 1 second", err)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// session processes tasks of one stream until it breaks
+func (a *Agent) session(addr string) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := taskpb.NewTaskServiceClient(conn).Connect(ctx)
+	if err != nil {
+		return err
+	}
+	s := &agentStream{stream: stream}
+
+	if err := s.register(a.registration()); err != nil {
+		return err
+	}
+	go s.heartbeats(ctx)
+	if err := s.ready(int32(a.computingPower)); err != nil {
+		return err
+	}
+
+	tasks := make(chan *service.Task)
+	var wg sync.WaitGroup
+	for i := 0; i < a.computingPower; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for task := range tasks {
+				a.streamTask(workerID, s, task)
+			}
+		}(i)
+	}
+	defer wg.Wait()
+	defer close(tasks)
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return errors.New("closed by orchestrator")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.Message.(type) {
+		case *taskpb.OrchestratorMessage_Task:
+			tasks <- rpc.TaskFromProto(m.Task)
+		case *taskpb.OrchestratorMessage_Rejected:
+			log.Printf("Orchestrator rejected report for task %s: %s", m.Rejected.GetTaskId(), m.Rejected.GetMessage())
+		}
+	}
+}
+
+// streamTask computes a task, reports the outcome and asks for the next task
+func (a *Agent) streamTask(workerID int, s *agentStream, task *service.Task) {
+	log.Printf("Worker %d: Processing task %s: %s %v", workerID, task.ID, task.Operation, task.Args)
+
+	msg := &taskpb.AgentMessage{}
+	result, err := a.processTask(task)
+	if err != nil {
+		log.Printf("Worker %d: Error processing task %s: %v", workerID, task.ID, err)
+		msg.Message = &taskpb.AgentMessage_Error{Error: &taskpb.TaskError{
+			Id:      task.ID,
+			LeaseId: task.LeaseID,
+			Code:    service.FailureCode(err),
+			Message: err.Error(),
+		}}
+	} else {
+		log.Printf("Worker %d: Completed task %s with result %s", workerID, task.ID, result)
+		msg.Message = &taskpb.AgentMessage_Result{Result: &taskpb.TaskResult{
+			Id:      task.ID,
+			LeaseId: task.LeaseID,
+			Result:  string(result),
+		}}
+	}
+
+	if err := s.send(msg); err != nil {
+		log.Printf("Worker %d: Error submitting result for task %s: %v", workerID, task.ID, err)
+		return
+	}
+	if err := s.ready(1); err != nil {
+		log.Printf("Worker %d: Error requesting a task: %v", workerID, err)
+	}
+}
+
+// agentStream serializes sends of the workers on one stream
+type agentStream struct {
+	stream taskpb.TaskService_ConnectClient
+	mu     sync.Mutex
+}
+
+// register identifies the agent on the stream
+func (s *agentStream) register(reg RegisterRequest) error {
+	ops := make([]string, len(reg.Operations))
+	for i, op := range reg.Operations {
+		ops[i] = string(op)
+	}
+	affinity := make(map[string]int32)
+	for op, weight := range reg.Affinity {
+		affinity[string(op)] = int32(weight)
+	}
+	return s.send(&taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Register{Register: &taskpb.Register{
+			Id:             reg.ID,
+			Hostname:       reg.Hostname,
+			ComputingPower: int32(reg.ComputingPower),
+			Operations:     ops,
+			Affinity:       affinity,
+			Version:        reg.Version,
+		}},
+	})
+}
+
+// heartbeats sends a heartbeat every heartbeatInterval until the context is done
+func (s *agentStream) heartbeats(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.send(&taskpb.AgentMessage{
+				Message: &taskpb.AgentMessage_Heartbeat{Heartbeat: &taskpb.Heartbeat{}},
+			})
+			if err != nil {
+				log.Printf("Error sending heartbeat: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// ready grants the orchestrator credits for more tasks
+func (s *agentStream) ready(credits int32) error {
+	return s.send(&taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Ready{Ready: &taskpb.Ready{Credits: credits}},
+	})
+}
+
+// send writes a message to the stream
+func (s *agentStream) send(msg *taskpb.AgentMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(msg)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/w0ikid/megacalc/internal/service"
+	apperrors "github.com/w0ikid/megacalc/pkg/errors"
+)
+
+// maxBatchSize is the largest number of expressions accepted in one batch
+const maxBatchSize = 10000
+
+// maxBatchRequestSize is the largest body accepted for a batch
+const maxBatchRequestSize = 16 << 20
+
+// errTrailingData is returned for a JSON array followed by anything but white space
+var errTrailingData = errors.New("unexpected data after the array")
+
+// BatchResponse represents the outcome of a batch submission, with a result
+// for every expression in the order they were sent
+type BatchResponse struct {
+	Atomic    bool                `json:"atomic"`
+	Submitted int                 `json:"submitted"`
+	Failed    int                 `json:"failed"`
+	Results   []BatchItemResponse `json:"results"`
+}
+
+// BatchItemResponse represents the ID of a submitted expression, or the reason it was rejected.
+// An item of a rejected atomic batch that had no error of its own has neither.
+type BatchItemResponse struct {
+	Index int                      `json:"index"`
+	ID    string                   `json:"id,omitempty"`
+	Error *apperrors.ErrorResponse `json:"error,omitempty"`
+}
+
+// CalculateBatch handles the request to calculate many expressions at once. The body is
+// a JSON array of expression requests or a stream of them, one per line (NDJSON).
+// With atomic=true either every expression is submitted or none is, otherwise the
+// valid expressions are submitted and the invalid ones reported.
+func (h *Handler) CalculateBatch(c *gin.Context) {
+	atomic := false
+	if value := c.Query("atomic"); value != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(value); err != nil {
+			respondError(c, apperrors.NewUnprocessableEntityError("invalid atomic flag", err).WithDetails("invalid_request", nil))
+			return
+		}
+	}
+
+	reqs, err := readBatch(http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchRequestSize))
+	if errors.Is(err, errTrailingData) {
+		respondError(c, apperrors.NewBadRequestError("invalid request", err).WithDetails("invalid_request", nil))
+		return
+	}
+	if err != nil {
+		respondError(c, requestError(err))
+		return
+	}
+
+	response := BatchResponse{Atomic: atomic, Results: make([]BatchItemResponse, len(reqs))}
+
+	// Options are checked here, expressions by the service under a single lock
+	var items []service.BatchItem
+	var indexes []int
+	for i, req := range reqs {
+		response.Results[i].Index = i
+		if req.Expression == "" {
+			resp := apperrors.NewUnprocessableEntityError("invalid request", errors.New("expression is required")).WithDetails("invalid_request", nil).Response()
+			response.Results[i].Error = &resp
+			continue
+		}
+		opts, appErr := expressionOptions(req)
+		if appErr != nil {
+			resp := appErr.Response()
+			response.Results[i].Error = &resp
+			continue
+		}
+		items = append(items, service.BatchItem{Expression: req.Expression, Options: opts})
+		indexes = append(indexes, i)
+	}
+
+	// An atomic batch with invalid options is rejected before its expressions are parsed
+	if !atomic || len(items) == len(reqs) {
+		for j, result := range h.service.SubmitBatch(items, atomic) {
+			i := indexes[j]
+			if result.Err != nil {
+				resp := expressionError(reqs[i].Expression, result.Err).Response()
+				response.Results[i].Error = &resp
+				continue
+			}
+			response.Results[i].ID = result.ID
+		}
+	}
+
+	for _, result := range response.Results {
+		if result.ID != "" {
+			response.Submitted++
+		}
+		if result.Error != nil {
+			response.Failed++
+		}
+	}
+
+	switch {
+	case response.Submitted == 0:
+		c.JSON(http.StatusUnprocessableEntity, response)
+	case response.Failed > 0:
+		c.JSON(http.StatusMultiStatus, response)
+	default:
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// readBatch reads the expression requests of a batch, either a JSON array or
+// one JSON object per line. The requests are decoded one at a time, and reading
+// stops as soon as there are more than maxBatchSize of them.
+func readBatch(body io.Reader) ([]ExpressionRequest, error) {
+	r := bufio.NewReader(body)
+
+	// The first character tells an array from a stream of objects
+	first, err := peekNonSpace(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqs []ExpressionRequest
+	decoder := json.NewDecoder(r)
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		for decoder.More() && len(reqs) <= maxBatchSize {
+			var req ExpressionRequest
+			if err := decoder.Decode(&req); err != nil {
+				return nil, fmt.Errorf("item %d: %w", len(reqs), err)
+			}
+			reqs = append(reqs, req)
+		}
+		if len(reqs) <= maxBatchSize {
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			if _, err := decoder.Token(); err != io.EOF {
+				return nil, errTrailingData
+			}
+		}
+	} else {
+		for {
+			var req ExpressionRequest
+			err := decoder.Decode(&req)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", len(reqs), err)
+			}
+			reqs = append(reqs, req)
+			if len(reqs) > maxBatchSize {
+				break
+			}
+		}
+	}
+
+	if len(reqs) == 0 {
+		return nil, errors.New("the batch is empty")
+	}
+	if len(reqs) > maxBatchSize {
+		return nil, fmt.Errorf("a batch holds at most %d expressions", maxBatchSize)
+	}
+	return reqs, nil
+}
+
+// peekNonSpace skips leading white space and returns the next character without consuming it
+func peekNonSpace(r *bufio.Reader) (rune, error) {
+	for {
+		ch, _, err := r.ReadRune()
+		if err == io.EOF {
+			return 0, errors.New("the batch is empty")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(ch) {
+			return ch, r.UnreadRune()
+		}
+	}
+}
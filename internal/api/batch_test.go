@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postBatch(router http.Handler, query, contentType, body string) (*httptest.ResponseRecorder, BatchResponse) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate/batch"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+
+	var resp BatchResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestCalculateBatch(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	// Best effort: valid items are submitted, invalid ones reported in place
+	body := `[{"expression": "2+2"}, {"expression": "2+*2"}, {"expression": "3", "mode": "octal"}, {"expression": "6/3", "priority": 5}]`
+	w, resp := postBatch(router, "", "application/json", body)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.False(t, resp.Atomic)
+	assert.Equal(t, 2, resp.Submitted)
+	assert.Equal(t, 2, resp.Failed)
+	if assert.Len(t, resp.Results, 4) {
+		assert.NotEmpty(t, resp.Results[0].ID)
+		assert.Equal(t, 1, resp.Results[1].Index)
+		assert.Equal(t, "syntax_error", resp.Results[1].Error.Code)
+		assert.Equal(t, "invalid_mode", resp.Results[2].Error.Code)
+		assert.NotEmpty(t, resp.Results[3].ID)
+	}
+	expr, found := h.service.GetExpression(resp.Results[3].ID)
+	assert.True(t, found)
+	assert.Equal(t, 5, expr.Priority)
+
+	// All or nothing: one invalid item rejects the batch
+	submitted := len(h.service.GetExpressions())
+	w, resp = postBatch(router, "?atomic=true", "application/json", `[{"expression": "1+1"}, {"expression": "(1"}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.True(t, resp.Atomic)
+	assert.Equal(t, 0, resp.Submitted)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Empty(t, resp.Results[0].ID)
+	assert.Nil(t, resp.Results[0].Error)
+	assert.Equal(t, "syntax_error", resp.Results[1].Error.Code)
+	assert.Len(t, h.service.GetExpressions(), submitted)
+
+	// NDJSON, one request per line
+	w, resp = postBatch(router, "?atomic=true", "application/x-ndjson", "{\"expression\": \"1+1\"}\n{\"expression\": \"2*3\", \"timeout_ms\": 1000}\n")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, resp.Submitted)
+	assert.Equal(t, 0, resp.Failed)
+	expr, _ = h.service.GetExpression(resp.Results[1].ID)
+	assert.Equal(t, "2*3", expr.Expression)
+	assert.NotNil(t, expr.Deadline)
+
+	// Missing expressions are reported per item
+	_, resp = postBatch(router, "", "application/json", `[{"expression": ""}]`)
+	assert.Equal(t, "invalid_request", resp.Results[0].Error.Code)
+}
+
+func TestCalculateBatchInvalidRequest(t *testing.T) {
+	router := setupTestHandler().SetupRouter()
+
+	for i, tc := range []struct{ query, body string }{
+		{"", ""},
+		{"", "[]"},
+		{"", `[{"expression": "1+1"}`},
+		{"", "{\"expression\": \"1+1\"}\nnot json\n"},
+		{"?atomic=maybe", `[{"expression": "1+1"}]`},
+		{"", "[" + strings.Repeat(`{"expression": "1"},`, maxBatchSize) + `{"expression": "1"}]`},
+	} {
+		w, _ := postBatch(router, tc.query, "application/json", tc.body)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, i)
+		assert.Contains(t, w.Body.String(), "invalid_request")
+	}
+
+	// Anything after the array is rejected
+	for _, body := range []string{`[{"expression": "1+1"}] [{"expression": "2+2"}]`, `[{"expression": "1+1"}]x`} {
+		w, _ := postBatch(router, "", "application/json", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), "invalid_request")
+	}
+	w, resp := postBatch(router, "", "application/json", "[{\"expression\": \"1+1\"}]\n")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, resp.Submitted)
+
+	// A body over the limit is not read to the end
+	w, _ = postBatch(router, "", "application/json", `[{"expression": "`+strings.Repeat("1+", maxBatchRequestSize/2)+`1"}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "request_too_large")
+}
+
+func TestReadBatchStopsAtLimit(t *testing.T) {
+	// Reading stops at the first item over the limit, the rest is never decoded
+	body := "[" + strings.Repeat(`{"expression": "1"},`, maxBatchSize+1) + "not json]"
+	_, err := readBatch(strings.NewReader(body))
+	assert.EqualError(t, err, fmt.Sprintf("a batch holds at most %d expressions", maxBatchSize))
+}
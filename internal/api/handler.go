@@ -1,14 +1,45 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/w0ikid/megacalc/internal/ast"
 	"github.com/w0ikid/megacalc/internal/service"
+	apperrors "github.com/w0ikid/megacalc/pkg/errors"
+)
+
+// MaxTaskWait is the longest time a task request is held open
+const MaxTaskWait = time.Minute
+
+// maxRequestSize is the largest body accepted for an expression request
+const maxRequestSize = 1 << 20
+
+// eventBuffer is the number of events an event stream may fall behind before it is closed
+const eventBuffer = 256
+
+// eventKeepAlive is how often an idle event stream sends a comment, so that proxies keep it open
+const eventKeepAlive = 15 * time.Second
+
+// Headers of idempotent expression submissions
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength is the longest idempotency key accepted
+	maxIdempotencyKeyLength = 255
 )
 
 // Handler handles HTTP requests
@@ -16,22 +47,65 @@ type Handler struct {
 	service *service.Service
 }
 
-// ExpressionRequest represents a request to calculate an expression
+// ExpressionRequest represents a request to calculate an expression.
+// TimeoutMs and Deadline limit the time the expression may take, if both are set the earlier one applies.
 type ExpressionRequest struct {
-	Expression string `json:"expression" binding:"required"`
+	Expression string     `json:"expression" binding:"required"`
+	Mode       string     `json:"mode,omitempty"`
+	Priority   int        `json:"priority,omitempty"`
+	TimeoutMs  int        `json:"timeout_ms,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
 }
 
-// TaskResultRequest represents a request to set a task result
+// TaskResultRequest represents a request to set a task result.
+// The result is a JSON number or, for fractions, a JSON string, so that no digits are lost.
+// The lease ID must be the one the task was handed out with.
 type TaskResultRequest struct {
-	ID     string  `json:"id" binding:"required"`
-	Result float64 `json:"result" binding:"required"`
+	ID      string        `json:"id" binding:"required"`
+	LeaseID string        `json:"lease_id" binding:"required"`
+	Result  service.Value `json:"result" binding:"required"`
+}
+
+// TaskErrorRequest represents a request to report that a task could not be computed
+type TaskErrorRequest struct {
+	ID      string `json:"id" binding:"required"`
+	LeaseID string `json:"lease_id" binding:"required"`
+	Code    string `json:"code" binding:"required"`
+	Message string `json:"message" binding:"required"`
+}
+
+// AgentRequest represents the registration of an agent
+type AgentRequest struct {
+	ID             string                    `json:"id"`
+	Hostname       string                    `json:"hostname"`
+	ComputingPower int                       `json:"computing_power"`
+	Operations     []service.Operation       `json:"operations"`
+	Affinity       map[service.Operation]int `json:"affinity,omitempty"`
+	Version        string                    `json:"version"`
+}
+
+// AgentsResponse represents a list of agents
+type AgentsResponse struct {
+	Agents []service.AgentInfo `json:"agents"`
 }
 
 // ExpressionResponse represents an expression response
 type ExpressionResponse struct {
-	ID     string             `json:"id"`
-	Status service.ExpressionStatus `json:"status"`
-	Result *float64           `json:"result,omitempty"`
+	ID          string                   `json:"id"`
+	Status      service.ExpressionStatus `json:"status"`
+	Result      *float64                 `json:"result,omitempty"`
+	Value       string                   `json:"value,omitempty"`
+	Decimal     string                   `json:"decimal,omitempty"`
+	Mode        service.NumericMode      `json:"mode,omitempty"`
+	Priority    int                      `json:"priority,omitempty"`
+	MinMakespan int64                    `json:"min_makespan_ms"`
+	Makespan    *int64                   `json:"makespan_ms,omitempty"`
+	SubmittedAt time.Time                `json:"submitted_at"`
+	CompletedAt *time.Time               `json:"completed_at,omitempty"`
+	FinishedAt  *time.Time               `json:"finished_at,omitempty"`
+	Deadline    *time.Time               `json:"deadline,omitempty"`
+	ErrorCode   string                   `json:"error_code,omitempty"`
+	Reason      string                   `json:"reason,omitempty"`
 }
 
 // ExpressionsResponse represents a list of expressions
@@ -44,6 +118,12 @@ type ExpressionDetailResponse struct {
 	Expression ExpressionResponse `json:"expression"`
 }
 
+// ExpressionTasksResponse represents the tasks of an expression with their dependencies
+type ExpressionTasksResponse struct {
+	ExpressionID string             `json:"expression_id"`
+	Tasks        []service.TaskNode `json:"tasks"`
+}
+
 // TaskResponse represents a task response
 type TaskResponse struct {
 	Task *service.Task `json:"task,omitempty"`
@@ -64,22 +144,33 @@ func (h *Handler) SetupRouter() *gin.Engine {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", idempotencyKeyHeader},
+		ExposeHeaders:    []string{"Content-Length", idempotentReplayedHeader},
 		AllowCredentials: true,
 	}))
 
 	api := r.Group("/api/v1")
 	{
 		api.POST("/calculate", h.CalculateExpression)
+		api.POST("/calculate/batch", h.CalculateBatch)
 		api.GET("/expressions", h.GetExpressions)
 		api.GET("/expressions/:id", h.GetExpression)
+		api.GET("/expressions/:id/events", h.ExpressionEvents)
+		api.GET("/expressions/:id/tasks", h.GetExpressionTasks)
+		api.GET("/events", h.Events)
+		api.GET("/ws", h.WebSocket)
+		api.DELETE("/expressions/:id", h.CancelExpression)
+		api.GET("/agents", h.GetAgents)
+		api.GET("/metrics", h.GetMetrics)
 	}
 
 	internal := r.Group("/internal")
 	{
 		internal.GET("/task", h.GetTask)
 		internal.POST("/task", h.SetTaskResult)
+		internal.POST("/task/error", h.SetTaskError)
+		internal.POST("/agents", h.RegisterAgent)
+		internal.POST("/agents/:id/heartbeat", h.Heartbeat)
 	}
 
 	// Serve static files for the web interface
@@ -91,33 +182,136 @@ func (h *Handler) SetupRouter() *gin.Engine {
 
 // CalculateExpression handles the request to calculate an expression
 func (h *Handler) CalculateExpression(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestSize)
+
 	var req ExpressionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		respondError(c, requestError(err))
 		return
 	}
 
-	id, err := h.service.SubmitExpression(req.Expression)
-	if err != nil {
-		log.Printf("Error submitting expression: %v", err)
-		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	// Retries carrying the key of an accepted request get its expression back
+	if key := c.GetHeader(idempotencyKeyHeader); key != "" {
+		id, replayed, appErr := h.submitExpressionOnce(req, key)
+		if appErr != nil {
+			respondError(c, *appErr)
+			return
+		}
+		if replayed {
+			c.Header(idempotentReplayedHeader, "true")
+		}
+		c.JSON(http.StatusCreated, gin.H{"id": id})
+		return
+	}
+
+	id, appErr := h.submitExpression(req)
+	if appErr != nil {
+		respondError(c, *appErr)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
+// submitExpression validates the options of an expression request and submits it
+func (h *Handler) submitExpression(req ExpressionRequest) (string, *apperrors.AppError) {
+	opts, appErr := expressionOptions(req)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	id, err := h.service.SubmitExpressionWithOptions(req.Expression, opts)
+	if err != nil {
+		log.Printf("Error submitting expression: %v", err)
+		appErr := expressionError(req.Expression, err)
+		return "", &appErr
+	}
+	return id, nil
+}
+
+// submitExpressionOnce validates an expression request and submits it under an idempotency key,
+// it reports whether the ID is that of an expression submitted earlier with the key
+func (h *Handler) submitExpressionOnce(req ExpressionRequest, key string) (string, bool, *apperrors.AppError) {
+	if len(key) > maxIdempotencyKeyLength {
+		err := fmt.Errorf("%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+		appErr := apperrors.NewBadRequestError("invalid idempotency key", err).WithDetails("invalid_idempotency_key", nil)
+		return "", false, &appErr
+	}
+
+	// A retry gets its expression back even if the request would no longer pass, e.g. after its deadline
+	fingerprint := requestFingerprint(req)
+	id, replayed, err := h.service.LookupIdempotencyKey(key, fingerprint)
+	if err == nil && !replayed {
+		opts, appErr := expressionOptions(req)
+		if appErr != nil {
+			return "", false, appErr
+		}
+		id, replayed, err = h.service.SubmitExpressionOnce(key, fingerprint, req.Expression, opts)
+	}
+	if errors.Is(err, service.ErrIdempotencyConflict) {
+		appErr := apperrors.NewAppError(http.StatusConflict, "idempotency key conflict", err).WithDetails("idempotency_conflict", nil)
+		return "", false, &appErr
+	}
+	if err != nil {
+		log.Printf("Error submitting expression: %v", err)
+		appErr := expressionError(req.Expression, err)
+		return "", false, &appErr
+	}
+	return id, replayed, nil
+}
+
+// requestFingerprint identifies what an expression request asks for. Neither the
+// formatting of the JSON nor options spelled out with their default value, or a
+// deadline written in another time zone, change it.
+func requestFingerprint(req ExpressionRequest) string {
+	normalized := struct {
+		Expression string `json:"expression"`
+		Mode       string `json:"mode"`
+		Priority   int    `json:"priority"`
+		TimeoutMs  int    `json:"timeout_ms"`
+		Deadline   string `json:"deadline"`
+	}{
+		Expression: req.Expression,
+		Mode:       req.Mode,
+		Priority:   req.Priority,
+		TimeoutMs:  req.TimeoutMs,
+	}
+	if mode, err := service.ParseNumericMode(req.Mode); err == nil {
+		normalized.Mode = string(mode)
+	}
+	if req.Deadline != nil {
+		normalized.Deadline = req.Deadline.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// expressionOptions validates the mode and time limits of an expression request
+func expressionOptions(req ExpressionRequest) (service.ExpressionOptions, *apperrors.AppError) {
+	mode, err := service.ParseNumericMode(req.Mode)
+	if err != nil {
+		appErr := apperrors.NewUnprocessableEntityError("invalid mode", err).WithDetails("invalid_mode", nil)
+		return service.ExpressionOptions{}, &appErr
+	}
+
+	deadline, err := requestDeadline(req, time.Now())
+	if err != nil {
+		appErr := apperrors.NewUnprocessableEntityError("invalid deadline", err).WithDetails("invalid_deadline", nil)
+		return service.ExpressionOptions{}, &appErr
+	}
+
+	return service.ExpressionOptions{Mode: mode, Priority: req.Priority, Deadline: deadline}, nil
+}
+
 // GetExpressions handles the request to get all expressions
 func (h *Handler) GetExpressions(c *gin.Context) {
 	expressions := h.service.GetExpressions()
 	
 	var response ExpressionsResponse
 	for _, expr := range expressions {
-		response.Expressions = append(response.Expressions, ExpressionResponse{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
-		})
+		response.Expressions = append(response.Expressions, newExpressionResponse(&expr))
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -134,17 +328,170 @@ func (h *Handler) GetExpression(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, ExpressionDetailResponse{
-		Expression: ExpressionResponse{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
-		},
+		Expression: newExpressionResponse(expr),
+	})
+}
+
+// GetExpressionTasks handles the request to get the task graph of an expression,
+// as JSON or, with ?format=dot, as a Graphviz digraph
+func (h *Handler) GetExpressionTasks(c *gin.Context) {
+	id := c.Param("id")
+	
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "dot" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("invalid format: %q, expected json or dot", format)})
+		return
+	}
+	
+	tasks, err := h.service.GetExpressionTasks(id)
+	if errors.Is(err, service.ErrExpressionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "expression not found"})
+		return
+	}
+	
+	if format == "dot" {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(service.FormatDOT(id, tasks)))
+		return
+	}
+	c.JSON(http.StatusOK, ExpressionTasksResponse{ExpressionID: id, Tasks: tasks})
+}
+
+// CancelExpression handles the request to cancel an expression
+func (h *Handler) CancelExpression(c *gin.Context) {
+	expr, err := h.service.CancelExpression(c.Param("id"))
+	if errors.Is(err, service.ErrExpressionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "expression not found"})
+		return
+	}
+	if errors.Is(err, service.ErrExpressionFinished) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ExpressionDetailResponse{
+		Expression: newExpressionResponse(expr),
 	})
 }
 
+// ExpressionEvents streams the events of an expression as Server-Sent Events,
+// starting with its current status and ending when it finishes
+func (h *Handler) ExpressionEvents(c *gin.Context) {
+	id := c.Param("id")
+	
+	// Subscribe before reading the status, so that no transition is missed in between
+	sub := h.service.Events().Subscribe(id, eventBuffer)
+	defer sub.Close()
+	
+	expr, found := h.service.GetExpression(id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "expression not found"})
+		return
+	}
+	
+	current := service.Event{
+		Type:         service.EventExpressionStatus,
+		Time:         time.Now(),
+		ExpressionID: id,
+		Status:       expr.Status,
+		Result:       service.Value(expr.Value),
+		Mode:         expr.Mode,
+		ErrorCode:    expr.ErrorCode,
+		Reason:       expr.Reason,
+	}
+	streamEvents(c, sub, &current)
+}
+
+// Events streams the events of all expressions as Server-Sent Events
+func (h *Handler) Events(c *gin.Context) {
+	sub := h.service.Events().Subscribe("", eventBuffer)
+	defer sub.Close()
+	
+	streamEvents(c, sub, nil)
+}
+
+// streamEvents writes the events of a subscription until the client goes away or
+// falls behind. A stream that starts with the status of an expression ends when
+// the expression finishes.
+func streamEvents(c *gin.Context, sub *service.Subscription, current *service.Event) {
+	// Send the headers right away, the client knows that it is subscribed once they arrive
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+	
+	if current != nil {
+		writeEvent(c, *current)
+		if current.Status.Finished() {
+			return
+		}
+	}
+	
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case event, ok := <-sub.Events():
+			// A closed subscription fell behind, the client reconnects and reads the state again
+			if !ok {
+				return
+			}
+			writeEvent(c, event)
+			if current != nil && event.Type == service.EventExpressionStatus && event.Status.Finished() {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes an event to a Server-Sent Events stream and flushes it to the client
+func writeEvent(c *gin.Context, event service.Event) {
+	id := ""
+	if event.Seq > 0 {
+		id = strconv.FormatUint(event.Seq, 10)
+	}
+	c.Render(-1, sse.Event{Id: id, Event: string(event.Type), Data: event})
+	c.Writer.Flush()
+}
+
 // GetTask handles the request to get a task
+// With ?wait=<duration> the request is held open until a task is ready or the time is up,
+// ?agent_id=<id> identifies a registered agent, repeated ?op=<operation> limits the tasks
+// to the given operations and ?affinity=<operation>:<weight> prefers some of them.
 func (h *Handler) GetTask(c *gin.Context) {
-	task, found := h.service.GetTask()
+	wait, err := parseWait(c.Query("wait"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ops, err := service.ParseOperations(c.QueryArray("op"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	affinity, err := service.ParseAffinity(c.QueryArray("affinity"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := service.TaskRequest{AgentID: c.Query("agent_id"), Operations: ops, Affinity: affinity}
+	var task *service.Task
+	var found bool
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+		task, found = h.service.WaitForTask(ctx, req)
+	} else {
+		task, found = h.service.AssignTask(req)
+	}
 	if !found {
 		c.JSON(http.StatusNotFound, gin.H{"error": "no task available"})
 		return
@@ -153,6 +500,21 @@ func (h *Handler) GetTask(c *gin.Context) {
 	c.JSON(http.StatusOK, TaskResponse{Task: task})
 }
 
+// parseWait parses the long-poll duration of a task request, capped at MaxTaskWait
+func parseWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait duration: %q", value)
+	}
+	if wait > MaxTaskWait {
+		wait = MaxTaskWait
+	}
+	return wait, nil
+}
+
 // SetTaskResult handles the request to set a task result
 func (h *Handler) SetTaskResult(c *gin.Context) {
 	var req TaskResultRequest
@@ -161,15 +523,178 @@ func (h *Handler) SetTaskResult(c *gin.Context) {
 		return
 	}
 
-	err := h.service.SetTaskResult(req.ID, req.Result)
+	err := h.service.SetTaskResult(req.ID, req.LeaseID, req.Result)
+	if errors.Is(err, service.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrStaleLease) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// SetTaskError handles the request to report a task that could not be computed
+func (h *Handler) SetTaskError(c *gin.Context) {
+	var req TaskErrorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.SetTaskError(req.ID, req.LeaseID, req.Code, req.Message)
+	if errors.Is(err, service.ErrTaskNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
+	if errors.Is(err, service.ErrStaleLease) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// RegisterAgent handles the registration of an agent
+func (h *Handler) RegisterAgent(c *gin.Context) {
+	var req AgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	names := make([]string, len(req.Operations))
+	for i, op := range req.Operations {
+		names[i] = string(op)
+	}
+	ops, err := service.ParseOperations(names)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	agent := h.service.RegisterAgent(service.AgentRegistration{
+		ID:             req.ID,
+		Hostname:       req.Hostname,
+		ComputingPower: req.ComputingPower,
+		Operations:     ops,
+		Affinity:       req.Affinity,
+		Version:        req.Version,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"agent": agent})
+}
+
+// Heartbeat handles the heartbeat of a registered agent
+func (h *Handler) Heartbeat(c *gin.Context) {
+	err := h.service.Heartbeat(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// GetAgents handles the request to list the registered agents
+func (h *Handler) GetAgents(c *gin.Context) {
+	c.JSON(http.StatusOK, AgentsResponse{Agents: h.service.GetAgents()})
+}
+
+// GetMetrics handles the request to get the amount of state held and the garbage collector statistics
+func (h *Handler) GetMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.GetMetrics())
+}
+
+// newExpressionResponse converts an expression into its API representation
+func newExpressionResponse(expr *service.ExpressionData) ExpressionResponse {
+	return ExpressionResponse{
+		ID:          expr.ID,
+		Status:      expr.Status,
+		Result:      expr.Result,
+		Value:       expr.Value,
+		Decimal:     expr.Decimal,
+		Mode:        expr.Mode,
+		Priority:    expr.Priority,
+		MinMakespan: expr.MinMakespan,
+		Makespan:    expr.Makespan,
+		SubmittedAt: expr.SubmittedAt,
+		CompletedAt: expr.CompletedAt,
+		FinishedAt:  expr.FinishedAt,
+		Deadline:    expr.Deadline,
+		ErrorCode:   expr.ErrorCode,
+		Reason:      expr.Reason,
+	}
+}
+
+// requestDeadline returns the absolute deadline of an expression request,
+// the zero time if the request sets neither a timeout nor a deadline
+func requestDeadline(req ExpressionRequest, now time.Time) (time.Time, error) {
+	var deadline time.Time
+	if req.TimeoutMs < 0 {
+		return deadline, fmt.Errorf("timeout_ms must not be negative, got %d", req.TimeoutMs)
+	}
+	if req.TimeoutMs > 0 {
+		deadline = now.Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	}
+	if req.Deadline != nil {
+		if !req.Deadline.After(now) {
+			return time.Time{}, fmt.Errorf("deadline %s is in the past", req.Deadline.Format(time.RFC3339))
+		}
+		if deadline.IsZero() || req.Deadline.Before(deadline) {
+			deadline = *req.Deadline
+		}
+	}
+	return deadline, nil
+}
+
+// expressionError converts an error from SubmitExpression into an AppError,
+// turning syntax errors into details with positions and caret snippets
+func expressionError(expression string, err error) apperrors.AppError {
+	var syntaxErrors ast.ErrorList
+	if !errors.As(err, &syntaxErrors) {
+		return apperrors.NewUnprocessableEntityError("invalid expression", err).WithDetails("invalid_expression", nil)
+	}
+
+	details := make([]apperrors.Detail, len(syntaxErrors))
+	for i, e := range syntaxErrors {
+		details[i] = apperrors.Detail{
+			Code:     "syntax_error",
+			Message:  e.Message,
+			Position: e.Pos,
+			Token:    e.Token,
+			Expected: e.Expected,
+			Hint:     e.Snippet(expression),
+		}
+	}
+	return apperrors.NewUnprocessableEntityError("invalid expression", err).WithDetails("syntax_error", details)
+}
+
+// respondError writes an AppError as a structured JSON response
+func respondError(c *gin.Context, err apperrors.AppError) {
+	c.JSON(err.Code, err.Response())
+}
+
+// requestError describes a request body that could not be read, a body over
+// the size limit gets its own status
+func requestError(err error) apperrors.AppError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apperrors.NewAppError(http.StatusRequestEntityTooLarge, "request too large", err).WithDetails("request_too_large", nil)
+	}
+	return apperrors.NewUnprocessableEntityError("invalid request", err).WithDetails("invalid_request", nil)
+}
+
 // Start starts the server
 func (h *Handler) Start(addr string) error {
 	r := h.SetupRouter()
@@ -178,12 +703,93 @@ func (h *Handler) Start(addr string) error {
 
 // GetOperationTimes gets the operation times from environment variables
 func GetOperationTimes() service.OperationTimes {
+	// Every built-in function has its own TIME_FUNCTION_<NAME>_MS setting
+	functionTimes := make(map[string]int)
+	for _, name := range service.FunctionNames() {
+		functionTimes[name] = getEnvInt("TIME_FUNCTION_"+strings.ToUpper(name)+"_MS", 1000)
+	}
+
 	return service.OperationTimes{
 		Addition:       getEnvInt("TIME_ADDITION_MS", 1000),
 		Subtraction:    getEnvInt("TIME_SUBTRACTION_MS", 1000),
 		Multiplication: getEnvInt("TIME_MULTIPLICATIONS_MS", 1000),
 		Division:       getEnvInt("TIME_DIVISIONS_MS", 1000),
+		Negation:       getEnvInt("TIME_NEGATION_MS", 1000),
+		Exponentiation: getEnvInt("TIME_EXPONENTIATION_MS", 1000),
+		Functions:      functionTimes,
+	}
+}
+
+// GetNumericMode gets the default numeric mode and decimal precision from environment variables
+func GetNumericMode() (service.NumericMode, int) {
+	mode, err := service.ParseNumericMode(os.Getenv("NUMERIC_MODE"))
+	if err != nil {
+		log.Printf("Invalid NUMERIC_MODE: %v, using %s", err, service.FloatMode)
+		mode = service.FloatMode
+	}
+	return mode, getEnvInt("DECIMAL_PRECISION", service.DefaultDecimalPrecision)
+}
+
+// GetLeaseGrace gets the lease grace period for handed out tasks from environment variables
+func GetLeaseGrace() time.Duration {
+	return time.Duration(getEnvInt("LEASE_GRACE_MS", int(service.DefaultLeaseGrace/time.Millisecond))) * time.Millisecond
+}
+
+// GetScheduler gets the scheduling policy from environment variables
+func GetScheduler() service.Scheduler {
+	scheduler, err := service.NewScheduler(os.Getenv("SCHEDULER"))
+	if err != nil {
+		log.Printf("Invalid SCHEDULER: %v, using %s", err, service.FIFOPolicy)
+		scheduler = service.NewFIFOScheduler()
+	}
+	return scheduler
+}
+
+// GetHeartbeatTimeout gets the time after which a silent agent is considered dead from environment variables
+func GetHeartbeatTimeout() time.Duration {
+	return time.Duration(getEnvInt("AGENT_HEARTBEAT_TIMEOUT_MS", int(service.DefaultHeartbeatTimeout/time.Millisecond))) * time.Millisecond
+}
+
+// GetAgentRemoveAfter gets the time after which a dead agent is removed from environment variables
+func GetAgentRemoveAfter() time.Duration {
+	return time.Duration(getEnvInt("AGENT_REMOVE_AFTER_MS", int(service.DefaultAgentRemoveAfter/time.Millisecond))) * time.Millisecond
+}
+
+// GetStore opens the store for expressions and tasks configured by environment variables:
+// a file store in STORE_DIR, or no store at all if it is unset
+func GetStore() (service.Store, error) {
+	dir := os.Getenv("STORE_DIR")
+	if dir == "" {
+		return service.NopStore{}, nil
 	}
+	return service.OpenFileStore(dir, getEnvInt("STORE_SNAPSHOT_EVERY", service.DefaultSnapshotEvery))
+}
+
+// GetRetention gets the retention policy for finished expressions from environment variables,
+// RETENTION_<STATUS>_MAX_AGE_MS overrides RETENTION_MAX_AGE_MS for one status
+func GetRetention() service.RetentionPolicy {
+	policy := service.RetentionPolicy{
+		MaxAge:       time.Duration(getEnvInt("RETENTION_MAX_AGE_MS", 0)) * time.Millisecond,
+		StatusMaxAge: make(map[service.ExpressionStatus]time.Duration),
+		MaxCount:     getEnvInt("RETENTION_MAX_COUNT", 0),
+	}
+	for _, status := range []service.ExpressionStatus{service.Completed, service.Failed, service.Cancelled} {
+		key := "RETENTION_" + strings.ToUpper(string(status)) + "_MAX_AGE_MS"
+		if os.Getenv(key) != "" {
+			policy.StatusMaxAge[status] = time.Duration(getEnvInt(key, 0)) * time.Millisecond
+		}
+	}
+	return policy
+}
+
+// GetGCInterval gets how often finished expressions are collected from environment variables
+func GetGCInterval() time.Duration {
+	return time.Duration(getEnvInt("GC_INTERVAL_MS", 60000)) * time.Millisecond
+}
+
+// GetIdempotencyTTL gets how long idempotency keys are remembered from environment variables
+func GetIdempotencyTTL() time.Duration {
+	return time.Duration(getEnvInt("IDEMPOTENCY_TTL_MS", int(service.DefaultIdempotencyTTL/time.Millisecond))) * time.Millisecond
 }
 
 // getEnvInt gets an integer environment variable or returns a default value
@@ -1,14 +1,18 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/w0ikid/megacalc/internal/service"
+	apperrors "github.com/w0ikid/megacalc/pkg/errors"
 )
 
 func setupTestHandler() *Handler {
@@ -54,6 +58,140 @@ func TestCalculateExpression(t *testing.T) {
 	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 }
 
+func TestCalculateExpressionSyntaxError(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	reqBody := ExpressionRequest{Expression: "2 + * 2 + (3"}
+	jsonReq, _ := json.Marshal(reqBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var resp apperrors.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "syntax_error", resp.Code)
+	assert.NotEmpty(t, resp.Error)
+	if assert.NotNil(t, resp.Position) {
+		assert.Equal(t, 4, *resp.Position)
+	}
+	assert.Equal(t, "2 + * 2 + (3\n    ^", resp.Hint)
+
+	// Every syntax error is reported, not only the first one
+	if assert.Len(t, resp.Details, 2) {
+		assert.Equal(t, "*", resp.Details[0].Token)
+		assert.Equal(t, "number, function or (", resp.Details[0].Expected)
+		assert.Equal(t, 12, resp.Details[1].Position)
+		assert.Equal(t, ")", resp.Details[1].Expected)
+	}
+}
+
+func TestCalculateExpressionTooDeep(t *testing.T) {
+	router := setupTestHandler().SetupRouter()
+
+	// Deep nesting is a syntax error rather than a stack overflow
+	jsonReq, _ := json.Marshal(ExpressionRequest{Expression: strings.Repeat("(", 500000)})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "nested deeper")
+
+	// Bodies over the limit are not read
+	jsonReq, _ = json.Marshal(ExpressionRequest{Expression: strings.Repeat("1+", maxRequestSize/2) + "1"})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "request_too_large")
+}
+
+func TestCalculateExpressionMode(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	// A bare literal completes immediately and shows the exact value
+	reqBody := ExpressionRequest{Expression: "0.10", Mode: "decimal"}
+	jsonReq, _ := json.Marshal(reqBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var addResp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &addResp)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/"+addResp["id"], nil)
+
+	router.ServeHTTP(w, req)
+
+	var resp ExpressionDetailResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, service.DecimalMode, resp.Expression.Mode)
+	assert.Equal(t, "0.1", resp.Expression.Value)
+
+	// Fractions are reported by agents as strings
+	reqBody = ExpressionRequest{Expression: "1/3", Mode: "rational"}
+	jsonReq, _ = json.Marshal(reqBody)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &addResp)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task", nil)
+
+	router.ServeHTTP(w, req)
+
+	var taskResp TaskResponse
+	json.Unmarshal(w.Body.Bytes(), &taskResp)
+	assert.Equal(t, service.RationalMode, taskResp.Task.Mode)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/task", bytes.NewBufferString(`{"id": "`+taskResp.Task.ID+`", "lease_id": "`+taskResp.Task.LeaseID+`", "result": "1/3"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/"+addResp["id"], nil)
+
+	router.ServeHTTP(w, req)
+
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "1/3", resp.Expression.Value)
+	assert.NotEmpty(t, resp.Expression.Decimal)
+
+	// Unknown modes are rejected
+	reqBody = ExpressionRequest{Expression: "2+2", Mode: "imaginary"}
+	jsonReq, _ = json.Marshal(reqBody)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
 func TestGetExpressions(t *testing.T) {
 	h := setupTestHandler()
 	router := h.SetupRouter()
@@ -147,8 +285,7 @@ func TestGetTask(t *testing.T) {
 	var resp TaskResponse
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.NotNil(t, resp.Task)
-	assert.Equal(t, "2", resp.Task.Arg1)
-	assert.Equal(t, "2", resp.Task.Arg2)
+	assert.Equal(t, []service.Operand{{Value: "2"}, {Value: "2"}}, resp.Task.Args)
 	assert.Equal(t, service.Addition, resp.Task.Operation)
 }
 
@@ -178,8 +315,9 @@ func TestSetTaskResult(t *testing.T) {
 	
 	// Set the result
 	resultReq := TaskResultRequest{
-		ID:     taskID,
-		Result: 4,
+		ID:      taskID,
+		LeaseID: taskResp.Task.LeaseID,
+		Result:  "4",
 	}
 	jsonReq, _ = json.Marshal(resultReq)
 	
@@ -191,10 +329,20 @@ func TestSetTaskResult(t *testing.T) {
 	
 	assert.Equal(t, http.StatusOK, w.Code)
 	
+	// Duplicate result for the same lease
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/task", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+	
+	router.ServeHTTP(w, req)
+	
+	assert.Equal(t, http.StatusConflict, w.Code)
+	
 	// Set result for non-existent task
 	resultReq = TaskResultRequest{
-		ID:     "non-existent",
-		Result: 4,
+		ID:      "non-existent",
+		LeaseID: "lease",
+		Result:  "4",
 	}
 	jsonReq, _ = json.Marshal(resultReq)
 	
@@ -205,4 +353,480 @@ func TestSetTaskResult(t *testing.T) {
 	router.ServeHTTP(w, req)
 	
 	assert.Equal(t, http.StatusNotFound, w.Code)
-}
\ No newline at end of file
+}
+func TestSetTaskError(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	reqBody := ExpressionRequest{Expression: "1/0"}
+	jsonReq, _ := json.Marshal(reqBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	var addResp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &addResp)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task", nil)
+
+	router.ServeHTTP(w, req)
+
+	var taskResp TaskResponse
+	json.Unmarshal(w.Body.Bytes(), &taskResp)
+
+	// Report the failure
+	errorReq := TaskErrorRequest{
+		ID:      taskResp.Task.ID,
+		LeaseID: taskResp.Task.LeaseID,
+		Code:    service.FailureDivisionByZero,
+		Message: "division by zero",
+	}
+	jsonReq, _ = json.Marshal(errorReq)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/task/error", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The expression exposes the reason
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/"+addResp["id"], nil)
+
+	router.ServeHTTP(w, req)
+
+	var resp ExpressionDetailResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, service.Failed, resp.Expression.Status)
+	assert.Equal(t, service.FailureDivisionByZero, resp.Expression.ErrorCode)
+	assert.Equal(t, "division by zero", resp.Expression.Reason)
+
+	// A second report is a conflict
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/task/error", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestGetTaskWait(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	// The request is held open and answered once a task is ready
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.service.SubmitExpression("2+2")
+	}()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/internal/task?wait=5s", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Without a ready task the request times out
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task?wait=10ms", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Invalid durations are rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task?wait=soon", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestAgents(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	// Register an agent
+	agentReq := AgentRequest{ID: "agent-1", Hostname: "host", ComputingPower: 2, Operations: []service.Operation{service.Addition}, Version: "1.0.0"}
+	jsonReq, _ := json.Marshal(agentReq)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/internal/agents", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Send a heartbeat
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/agents/agent-1/heartbeat", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Heartbeats of unknown agents are rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/internal/agents/unknown/heartbeat", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// List the agents
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/agents", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp AgentsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Len(t, resp.Agents, 1)
+	assert.Equal(t, "agent-1", resp.Agents[0].ID)
+	assert.Equal(t, service.AgentAlive, resp.Agents[0].Status)
+	assert.Equal(t, []service.Operation{service.Addition}, resp.Agents[0].Operations)
+}
+
+func TestGetTaskOperations(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	h.service.SubmitExpression("2*3")
+
+	// An agent that can only add gets no task
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/internal/task?op=%2B&op=-", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task?op=*&affinity=*:5", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Unknown operations are rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/internal/task?op=%25", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCancelExpression(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	exprID, _ := h.service.SubmitExpression("2+2")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/expressions/"+exprID, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp ExpressionDetailResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, service.Cancelled, resp.Expression.Status)
+
+	// Cancelling again is a conflict
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/expressions/"+exprID, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/expressions/non-existent", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCalculateExpressionDeadline(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	// A timeout is turned into an absolute deadline, the earlier of both applies
+	later := time.Now().Add(time.Hour)
+	reqBody := ExpressionRequest{Expression: "2+2", TimeoutMs: 60000, Deadline: &later}
+	jsonReq, _ := json.Marshal(reqBody)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	expr, _ := h.service.GetExpression(resp["id"])
+	assert.NotNil(t, expr.Deadline)
+	assert.True(t, expr.Deadline.Before(later))
+
+	// Negative timeouts and deadlines in the past are rejected
+	earlier := time.Now().Add(-time.Minute)
+	for _, reqBody := range []ExpressionRequest{
+		{Expression: "2+2", TimeoutMs: -1},
+		{Expression: "2+2", Deadline: &earlier},
+	} {
+		jsonReq, _ := json.Marshal(reqBody)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBuffer(jsonReq))
+		req.Header.Set("Content-Type", "application/json")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_deadline")
+	}
+}
+
+func TestCalculateExpressionIdempotencyKey(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("retry-1", `{"expression": "2+2", "priority": 3}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	var first map[string]string
+	json.Unmarshal(w.Body.Bytes(), &first)
+
+	// A retry of the same request, however formatted, gets the same expression
+	w = post("retry-1", `{"priority":3,"expression":"2+2"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	var again map[string]string
+	json.Unmarshal(w.Body.Bytes(), &again)
+	assert.Equal(t, first["id"], again["id"])
+	assert.Len(t, h.service.GetExpressions(), 1)
+
+	// A retry after the deadline of the request passed still gets its expression
+	deadline := time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano)
+	w = post("retry-3", `{"expression": "3+3", "deadline": "`+deadline+`"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &first)
+	time.Sleep(60 * time.Millisecond)
+	w = post("retry-3", `{"expression": "3+3", "deadline": "`+deadline+`"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	json.Unmarshal(w.Body.Bytes(), &again)
+	assert.Equal(t, first["id"], again["id"])
+
+	// Default options spelled out do not make a different request
+	w = post("retry-1", `{"expression": "2+2", "priority": 3, "mode": "float"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	w = post("retry-4", `{"expression": "4+4", "deadline": "2099-01-01T12:00:00Z"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = post("retry-4", `{"expression": "4+4", "deadline": "2099-01-01T15:00:00+03:00", "timeout_ms": 0}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	// Reusing the key for a different request is a conflict
+	w = post("retry-1", `{"expression": "2+2", "priority": 4}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "idempotency_conflict")
+
+	// Invalid requests and keys are rejected without taking the key
+	w = post("retry-2", `{"expression": "2+"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = post("retry-2", `{"expression": "2+3"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = post(strings.Repeat("k", 256), `{"expression": "2+2"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_idempotency_key")
+}
+
+func TestGetMetrics(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	h.service.SubmitExpression("2+2")
+	h.service.SubmitExpression("3")
+	h.service.CollectGarbage()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/metrics", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp service.Metrics
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, 2, resp.Expressions)
+	assert.Equal(t, 1, resp.ExpressionsByStatus[service.Completed])
+	assert.Equal(t, 1, resp.Tasks)
+	assert.Equal(t, 1, resp.QueuedTasks)
+	assert.Equal(t, int64(1), resp.GC.Runs)
+}
+
+// readEvents reads the events of a Server-Sent Events stream until it ends
+func readEvents(t *testing.T, resp *http.Response, events chan<- service.Event) {
+	defer close(events)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event service.Event
+		assert.NoError(t, json.Unmarshal([]byte(data), &event))
+		events <- event
+	}
+}
+
+func TestExpressionEvents(t *testing.T) {
+	h := setupTestHandler()
+	server := httptest.NewServer(h.SetupRouter())
+	defer server.Close()
+
+	exprID, _ := h.service.SubmitExpression("2+2")
+
+	resp, err := http.Get(server.URL + "/api/v1/expressions/" + exprID + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan service.Event, 10)
+	go readEvents(t, resp, events)
+
+	// The stream starts with the current status
+	first := <-events
+	assert.Equal(t, service.EventExpressionStatus, first.Type)
+	assert.Equal(t, service.InProcess, first.Status)
+
+	task, _ := h.service.GetTask()
+	h.service.SetTaskResult(task.ID, task.LeaseID, "4")
+
+	// The stream ends once the expression is completed
+	var types []service.EventType
+	var last service.Event
+	for event := range events {
+		types = append(types, event.Type)
+		last = event
+	}
+	assert.Equal(t, []service.EventType{service.EventTaskDispatched, service.EventTaskCompleted, service.EventExpressionStatus}, types)
+	assert.Equal(t, service.Completed, last.Status)
+	assert.Equal(t, service.Value("4"), last.Result)
+
+	// A finished expression only sends its status
+	resp, err = http.Get(server.URL + "/api/v1/expressions/" + exprID + "/events")
+	assert.NoError(t, err)
+	events = make(chan service.Event, 10)
+	readEvents(t, resp, events)
+	resp.Body.Close()
+	assert.Len(t, events, 1)
+
+	resp, err = http.Get(server.URL + "/api/v1/expressions/non-existent/events")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestEvents(t *testing.T) {
+	h := setupTestHandler()
+	server := httptest.NewServer(h.SetupRouter())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	events := make(chan service.Event, 10)
+	go readEvents(t, resp, events)
+
+	// The response headers arrive once the handler subscribed, so no event is missed
+	firstID, _ := h.service.SubmitExpression("1+2")
+	secondID, _ := h.service.SubmitExpression("3")
+
+	event := <-events
+	assert.Equal(t, firstID, event.ExpressionID)
+	assert.Equal(t, service.InProcess, event.Status)
+	event = <-events
+	assert.Equal(t, secondID, event.ExpressionID)
+	assert.Equal(t, service.Completed, event.Status)
+	assert.Equal(t, service.Value("3"), event.Result)
+	assert.Greater(t, event.Seq, uint64(0))
+}
+
+func TestGetExpressionTasks(t *testing.T) {
+	h := setupTestHandler()
+	router := h.SetupRouter()
+
+	exprID, _ := h.service.SubmitExpression("1+2*3")
+	leased, _ := h.service.GetTask()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/expressions/"+exprID+"/tasks", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The lease of a task being computed is not shown
+	assert.NotContains(t, w.Body.String(), leased.LeaseID)
+	assert.NotContains(t, w.Body.String(), "lease")
+
+	var resp ExpressionTasksResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, exprID, resp.ExpressionID)
+	assert.Len(t, resp.Tasks, 2)
+	assert.Equal(t, []string{"task_1"}, resp.Tasks[1].Dependencies)
+
+	// Graphviz export
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/"+exprID+"/tasks?format=dot", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/vnd.graphviz")
+	assert.Contains(t, w.Body.String(), `"task_1" -> "task_2";`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/"+exprID+"/tasks?format=svg", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/non-existent/tasks", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/w0ikid/megacalc/internal/service"
+	apperrors "github.com/w0ikid/megacalc/pkg/errors"
+)
+
+// Settings of WebSocket connections
+const (
+	// wsSendBuffer is the number of messages a client may fall behind before it is disconnected
+	wsSendBuffer = 64
+	// wsMaxSubscriptions is the number of unfinished expressions a connection may watch at once
+	wsMaxSubscriptions = 1024
+	// wsMaxMessageSize is the largest message accepted from a client
+	wsMaxMessageSize = 64 * 1024
+	// wsWriteWait is how long writing a message may take
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long the client may stay silent, it must answer pings in time
+	wsPongWait = 60 * time.Second
+	// wsPingInterval is how often the server pings the client
+	wsPingInterval = wsPongWait * 9 / 10
+)
+
+// Types of the messages sent by WebSocket clients
+const (
+	wsSubmit      = "submit"
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+)
+
+// Types of the messages sent to WebSocket clients
+const (
+	wsSubmitted    = "submitted"
+	wsSubscribed   = "subscribed"
+	wsUnsubscribed = "unsubscribed"
+	wsExpression   = "expression"
+	wsError        = "error"
+)
+
+// WSRequest is a message from a WebSocket client. Submit takes the fields of an
+// ExpressionRequest, Subscribe and Unsubscribe take the expression IDs. With
+// Subscribe set, a submitted expression is subscribed to right away.
+// The RequestID is chosen by the client and sent back with the reply.
+type WSRequest struct {
+	Type      string   `json:"type"`
+	RequestID string   `json:"request_id,omitempty"`
+	IDs       []string `json:"ids,omitempty"`
+	Subscribe bool     `json:"subscribe,omitempty"`
+	ExpressionRequest
+}
+
+// WSResponse is a message to a WebSocket client: the reply to a request, or the
+// state of a subscribed expression pushed whenever its status changes
+type WSResponse struct {
+	Type       string                   `json:"type"`
+	RequestID  string                   `json:"request_id,omitempty"`
+	ID         string                   `json:"id,omitempty"`
+	IDs        []string                 `json:"ids,omitempty"`
+	Expression *ExpressionResponse      `json:"expression,omitempty"`
+	Error      *apperrors.ErrorResponse `json:"error,omitempty"`
+}
+
+// upgrader accepts WebSocket connections from any origin, like the CORS settings of the API
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsConn is the state of one WebSocket connection, owned by the goroutine of WebSocket.
+// Subscriptions map the watched expressions to the status last sent to the client.
+// Sub receives the events of those expressions only, it is created on the first
+// subscription, so that a connection that only submits never listens to the bus.
+type wsConn struct {
+	h             *Handler
+	conn          *websocket.Conn
+	send          chan WSResponse
+	done          chan struct{}
+	sub           *service.Subscription
+	subscriptions map[string]service.ExpressionStatus
+}
+
+// WebSocket handles a WebSocket connection on which the client submits expressions,
+// subscribes to them and gets their state pushed. A client that does not read its
+// messages fast enough, or does not answer pings, is disconnected.
+func (h *Handler) WebSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered the request
+		log.Printf("Error upgrading to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ws := &wsConn{
+		h:             h,
+		conn:          conn,
+		send:          make(chan WSResponse, wsSendBuffer),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]service.ExpressionStatus),
+	}
+	requests := ws.readRequests()
+	go ws.writeResponses()
+	defer close(ws.send)
+	defer close(ws.done)
+	defer func() {
+		if ws.sub != nil {
+			ws.sub.Close()
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			if !ws.handle(req) {
+				return
+			}
+		case event, ok := <-ws.events():
+			if !ok {
+				ws.close(websocket.CloseTryAgainLater, "fell behind the events")
+				return
+			}
+			if !ws.push(event) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRequests reads the messages of the client until the connection fails,
+// every message and pong gives the client more time before it is considered gone
+func (ws *wsConn) readRequests() <-chan WSRequest {
+	requests := make(chan WSRequest)
+
+	ws.conn.SetReadLimit(wsMaxMessageSize)
+	ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go func() {
+		defer close(requests)
+		for {
+			_, data, err := ws.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+			// A message that is not JSON is answered like one of an unknown type
+			var req WSRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				req = WSRequest{}
+			}
+			select {
+			case requests <- req:
+			case <-ws.done:
+				return
+			}
+		}
+	}()
+	return requests
+}
+
+// writeResponses writes the queued messages until the queue is closed
+func (ws *wsConn) writeResponses() {
+	for resp := range ws.send {
+		ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := ws.conn.WriteJSON(resp); err != nil {
+			// Reading fails too once the connection is closed, which ends the handler
+			ws.conn.Close()
+			return
+		}
+	}
+}
+
+// reply queues a message for the client. A client whose queue is full is
+// disconnected instead of letting it hold back the server.
+func (ws *wsConn) reply(resp WSResponse) bool {
+	select {
+	case ws.send <- resp:
+		return true
+	default:
+		ws.close(websocket.CloseTryAgainLater, "too many unread messages")
+		return false
+	}
+}
+
+// replyError queues an error reply to a request
+func (ws *wsConn) replyError(requestID string, err apperrors.AppError) bool {
+	resp := err.Response()
+	return ws.reply(WSResponse{Type: wsError, RequestID: requestID, Error: &resp})
+}
+
+// close tells the client why the connection is closed
+func (ws *wsConn) close(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	ws.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteWait))
+}
+
+// handle answers a request of the client, it returns false if the connection must be closed
+func (ws *wsConn) handle(req WSRequest) bool {
+	switch req.Type {
+	case wsSubmit:
+		id, appErr := ws.h.submitExpression(req.ExpressionRequest)
+		if appErr != nil {
+			return ws.replyError(req.RequestID, *appErr)
+		}
+		if !ws.reply(WSResponse{Type: wsSubmitted, RequestID: req.RequestID, ID: id}) {
+			return false
+		}
+		if !req.Subscribe {
+			return true
+		}
+		if appErr := ws.checkSubscriptions([]string{id}); appErr != nil {
+			return ws.replyError(req.RequestID, *appErr)
+		}
+		return ws.subscribe(id)
+	case wsSubscribe:
+		ids := uniqueIDs(req.IDs)
+		for _, id := range ids {
+			if _, found := ws.h.service.GetExpression(id); !found {
+				return ws.replyError(req.RequestID, apperrors.NewNotFoundError("expression not found", errors.New(id)).WithDetails("not_found", nil))
+			}
+		}
+		if appErr := ws.checkSubscriptions(ids); appErr != nil {
+			return ws.replyError(req.RequestID, *appErr)
+		}
+		if !ws.reply(WSResponse{Type: wsSubscribed, RequestID: req.RequestID, IDs: ids}) {
+			return false
+		}
+		for _, id := range ids {
+			if !ws.subscribe(id) {
+				return false
+			}
+		}
+		return true
+	case wsUnsubscribe:
+		for _, id := range req.IDs {
+			ws.unsubscribe(id)
+		}
+		return ws.reply(WSResponse{Type: wsUnsubscribed, RequestID: req.RequestID, IDs: req.IDs})
+	default:
+		err := fmt.Errorf("expected %s, %s or %s", wsSubmit, wsSubscribe, wsUnsubscribe)
+		return ws.replyError(req.RequestID, apperrors.NewBadRequestError("invalid message", err).WithDetails("invalid_message", nil))
+	}
+}
+
+// checkSubscriptions returns an error if the connection can not watch the expressions
+// on top of those it watches already, which do not count again
+func (ws *wsConn) checkSubscriptions(ids []string) *apperrors.AppError {
+	added := make(map[string]bool)
+	for _, id := range ids {
+		if _, watched := ws.subscriptions[id]; !watched {
+			added[id] = true
+		}
+	}
+	if len(ws.subscriptions)+len(added) <= wsMaxSubscriptions {
+		return nil
+	}
+	err := fmt.Errorf("at most %d expressions can be watched at once", wsMaxSubscriptions)
+	appErr := apperrors.NewUnprocessableEntityError("too many subscriptions", err).WithDetails("too_many_subscriptions", nil)
+	return &appErr
+}
+
+// uniqueIDs returns the IDs without repetitions, in the order they first appear
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// events returns the events of the watched expressions, or nil before the first
+// subscription, which never delivers anything
+func (ws *wsConn) events() <-chan service.Event {
+	if ws.sub == nil {
+		return nil
+	}
+	return ws.sub.Events()
+}
+
+// subscribe sends the current state of an expression and, unless it is
+// finished, keeps sending it whenever the status changes
+func (ws *wsConn) subscribe(id string) bool {
+	if ws.sub == nil {
+		ws.sub = ws.h.service.Events().SubscribeWatched(eventBuffer)
+	}
+	// Watch before reading the state, so that no status change is missed
+	ws.sub.Watch(id)
+	ws.subscriptions[id] = ""
+	return ws.sendExpression(id)
+}
+
+// unsubscribe stops watching an expression
+func (ws *wsConn) unsubscribe(id string) {
+	delete(ws.subscriptions, id)
+	if ws.sub != nil {
+		ws.sub.Unwatch(id)
+	}
+}
+
+// push sends the state of a subscribed expression when its status changed
+func (ws *wsConn) push(event service.Event) bool {
+	if _, ok := ws.subscriptions[event.ExpressionID]; !ok || event.Type != service.EventExpressionStatus {
+		return true
+	}
+	return ws.sendExpression(event.ExpressionID)
+}
+
+// sendExpression sends the state of an expression unless the client already has
+// it with that status, finished expressions are not watched any longer
+func (ws *wsConn) sendExpression(id string) bool {
+	expr, found := ws.h.service.GetExpression(id)
+	if !found {
+		ws.unsubscribe(id)
+		return true
+	}
+	if ws.subscriptions[id] == expr.Status {
+		return true
+	}
+	ws.subscriptions[id] = expr.Status
+	if expr.Status.Finished() {
+		ws.unsubscribe(id)
+	}
+
+	resp := newExpressionResponse(expr)
+	return ws.reply(WSResponse{Type: wsExpression, ID: id, Expression: &resp})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/w0ikid/megacalc/internal/service"
+)
+
+// dialWebSocket connects to the WebSocket endpoint of a test server
+func dialWebSocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readResponse reads the next message from the server
+func readResponse(t *testing.T, conn *websocket.Conn) WSResponse {
+	var resp WSResponse
+	assert.NoError(t, conn.ReadJSON(&resp))
+	return resp
+}
+
+func TestWebSocket(t *testing.T) {
+	h := setupTestHandler()
+	server := httptest.NewServer(h.SetupRouter())
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	defer conn.Close()
+
+	// Submit and subscribe in one request, the current state follows the reply
+	conn.WriteJSON(WSRequest{Type: "submit", RequestID: "1", Subscribe: true, ExpressionRequest: ExpressionRequest{Expression: "2+2"}})
+
+	resp := readResponse(t, conn)
+	assert.Equal(t, "submitted", resp.Type)
+	assert.Equal(t, "1", resp.RequestID)
+	exprID := resp.ID
+
+	resp = readResponse(t, conn)
+	assert.Equal(t, "expression", resp.Type)
+	assert.Equal(t, service.InProcess, resp.Expression.Status)
+
+	// The result is pushed once the expression completes
+	task, _ := h.service.GetTask()
+	h.service.SetTaskResult(task.ID, task.LeaseID, "4")
+
+	resp = readResponse(t, conn)
+	assert.Equal(t, "expression", resp.Type)
+	assert.Equal(t, exprID, resp.ID)
+	assert.Equal(t, service.Completed, resp.Expression.Status)
+	assert.Equal(t, 4.0, *resp.Expression.Result)
+
+	// Subscribing to a finished expression sends its state
+	conn.WriteJSON(WSRequest{Type: "subscribe", RequestID: "2", IDs: []string{exprID}})
+	resp = readResponse(t, conn)
+	assert.Equal(t, "subscribed", resp.Type)
+	resp = readResponse(t, conn)
+	assert.Equal(t, service.Completed, resp.Expression.Status)
+
+	// Errors are answered with the request ID
+	conn.WriteJSON(WSRequest{Type: "submit", RequestID: "3", ExpressionRequest: ExpressionRequest{Expression: "2+*2"}})
+	resp = readResponse(t, conn)
+	assert.Equal(t, "error", resp.Type)
+	assert.Equal(t, "3", resp.RequestID)
+	assert.NotEmpty(t, resp.Error.Details)
+
+	conn.WriteJSON(WSRequest{Type: "subscribe", RequestID: "4", IDs: []string{"non-existent"}})
+	resp = readResponse(t, conn)
+	assert.Equal(t, "not_found", resp.Error.Code)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+	resp = readResponse(t, conn)
+	assert.Equal(t, "invalid_message", resp.Error.Code)
+}
+
+func TestWebSocketUnsubscribe(t *testing.T) {
+	h := setupTestHandler()
+	server := httptest.NewServer(h.SetupRouter())
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	defer conn.Close()
+
+	firstID, _ := h.service.SubmitExpression("1+1")
+	secondID, _ := h.service.SubmitExpression("2+2")
+
+	conn.WriteJSON(WSRequest{Type: "subscribe", IDs: []string{firstID, secondID}})
+	for i := 0; i < 3; i++ {
+		readResponse(t, conn)
+	}
+
+	conn.WriteJSON(WSRequest{Type: "unsubscribe", RequestID: "5", IDs: []string{firstID}})
+	resp := readResponse(t, conn)
+	assert.Equal(t, "unsubscribed", resp.Type)
+
+	// Only the expression still subscribed to is pushed
+	for _, result := range []string{"2", "4"} {
+		task, _ := h.service.GetTask()
+		h.service.SetTaskResult(task.ID, task.LeaseID, service.Value(result))
+	}
+	resp = readResponse(t, conn)
+	assert.Equal(t, secondID, resp.ID)
+	assert.Equal(t, service.Completed, resp.Expression.Status)
+}
+
+func TestWebSocketSubscriptionLimit(t *testing.T) {
+	ws := &wsConn{subscriptions: make(map[string]service.ExpressionStatus)}
+	for i := 0; i < wsMaxSubscriptions-1; i++ {
+		ws.subscriptions[fmt.Sprintf("expr_%d", i)] = service.InProcess
+	}
+
+	// Expressions watched already and repeated IDs do not count again
+	assert.Nil(t, ws.checkSubscriptions([]string{"expr_0", "expr_1", "new", "new"}))
+	appErr := ws.checkSubscriptions([]string{"new", "other"})
+	if assert.NotNil(t, appErr) {
+		assert.Equal(t, "too_many_subscriptions", appErr.Response().Code)
+	}
+
+	assert.Equal(t, []string{"a", "b"}, uniqueIDs([]string{"a", "b", "a", "b"}))
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	h := setupTestHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// Nothing writes the queue out, as if the client did not read its messages
+		ws := &wsConn{h: h, conn: conn, send: make(chan WSResponse, 1), subscriptions: make(map[string]service.ExpressionStatus)}
+		assert.True(t, ws.reply(WSResponse{Type: wsSubmitted}))
+		assert.False(t, ws.reply(WSResponse{Type: wsSubmitted}))
+	}))
+	defer server.Close()
+
+	conn := dialWebSocket(t, server)
+	defer conn.Close()
+
+	// The client is disconnected and told why
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), "unexpected error: %v", err)
+}
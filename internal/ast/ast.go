@@ -0,0 +1,152 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Span is a half-open range of character offsets in the source expression
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Node is a node of the expression syntax tree
+type Node interface {
+	// Pos returns the source range covered by the node
+	Pos() Span
+	// String returns a fully parenthesized form of the node
+	String() string
+}
+
+// Number is a numeric literal, kept as written to avoid precision loss
+type Number struct {
+	Value string
+	Span  Span
+}
+
+// UnaryOp is a prefix operator applied to a single operand
+type UnaryOp struct {
+	Op      string
+	Operand Node
+	Span    Span
+}
+
+// BinaryOp is an infix operator applied to two operands
+type BinaryOp struct {
+	Op    string
+	Left  Node
+	Right Node
+	Span  Span
+}
+
+// Call is a call of a built-in function
+type Call struct {
+	Name string
+	Args []Node
+	Span Span
+}
+
+// BadExpr stands in for a part of the source that could not be parsed
+type BadExpr struct {
+	Span Span
+}
+
+// Pos returns the source range of the literal
+func (n *Number) Pos() Span { return n.Span }
+
+// Pos returns the source range of the operator and its operand
+func (n *UnaryOp) Pos() Span { return n.Span }
+
+// Pos returns the source range of both operands
+func (n *BinaryOp) Pos() Span { return n.Span }
+
+// Pos returns the source range from the name to the closing parenthesis
+func (n *Call) Pos() Span { return n.Span }
+
+// Pos returns the source range that failed to parse
+func (n *BadExpr) Pos() Span { return n.Span }
+
+func (n *Number) String() string { return n.Value }
+
+func (n *UnaryOp) String() string { return fmt.Sprintf("(%s%s)", n.Op, n.Operand) }
+
+func (n *BinaryOp) String() string { return fmt.Sprintf("(%s %s %s)", n.Left, n.Op, n.Right) }
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+}
+
+func (n *BadExpr) String() string { return "<bad>" }
+
+// Inspect traverses the tree in depth-first order, calling f for every node.
+// Children of a node are skipped when f returns false for it.
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *UnaryOp:
+		Inspect(n.Operand, f)
+	case *BinaryOp:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *Call:
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	}
+}
+
+// Error is a syntax error at a character position in the source expression
+type Error struct {
+	Pos      int
+	Token    string
+	Expected string
+	Message  string
+}
+
+// Error returns the error message with its position
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos)
+}
+
+// Snippet returns the source expression with the offending token underlined:
+//
+//	2+*2
+//	  ^
+func (e *Error) Snippet(src string) string {
+	line := strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(src)
+	width := utf8.RuneCountInString(e.Token)
+	if width == 0 {
+		width = 1
+	}
+	return line + "\n" + strings.Repeat(" ", e.Pos) + "^" + strings.Repeat("~", width-1)
+}
+
+// ErrorList is a list of syntax errors ordered by position
+type ErrorList []*Error
+
+// Error returns the first error and the number of remaining ones
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil for an empty list and the list itself otherwise
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
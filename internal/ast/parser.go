@@ -0,0 +1,344 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind is the lexical class of a token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+// token is a lexical token with its source range
+type token struct {
+	kind tokenKind
+	text string
+	span Span
+}
+
+// describe returns a human readable name of the token for error messages
+func (t token) describe() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parser is a recursive-descent parser for arithmetic expressions:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = ("+" | "-") unary | power
+//	power   = primary [ ("^" | "**") unary ]
+//	primary = number | ident "(" [ expr { "," expr } ] ")" | "(" expr ")"
+//	number  = decimal [ ("e" | "E") [ "+" | "-" ] digits ], e.g. 1.5, .5, 2.5E3, 1e-9
+type parser struct {
+	src     string
+	tokens  []token
+	pos     int
+	depth   int
+	tooDeep bool
+	errors  ErrorList
+}
+
+// maxDepth limits the nesting of parentheses, function calls and operators that
+// the parser recurses into, so that no input can exhaust the stack
+const maxDepth = 1000
+
+// Parse parses an expression. It always returns a tree, with BadExpr nodes
+// in place of unparsable parts, and an ErrorList with every syntax error.
+func Parse(src string) (Node, error) {
+	p := &parser{src: src}
+	p.scan()
+
+	node := p.parseExpr()
+	for p.peek().kind != tokenEOF {
+		t := p.peek()
+		p.errorf(t, "operator or end of expression", "unexpected %s", t.describe())
+		if t.kind == tokenRParen || t.kind == tokenComma {
+			p.next()
+			if k := p.peek().kind; k == tokenEOF || k == tokenRParen || k == tokenComma {
+				continue
+			}
+		}
+		// Keep parsing the rest to report its errors as well
+		p.parseExpr()
+	}
+
+	sort.SliceStable(p.errors, func(i, j int) bool { return p.errors[i].Pos < p.errors[j].Pos })
+	return node, p.errors.Err()
+}
+
+// scan splits the source into tokens, reporting invalid characters. Spans and
+// error positions count characters rather than bytes, so they point at the right
+// place in the expression even after a multibyte character.
+func (p *parser) scan() {
+	src := p.src
+	pos := 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || c == '.':
+			dots := 0
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				if src[i] == '.' {
+					dots++
+				}
+				i++
+			}
+			mantissa := src[start:i]
+			n, valid := scanExponent(src[i:])
+			i += n
+			text := src[start:i]
+			if dots > 1 || mantissa == "." || !valid {
+				p.errors = append(p.errors, &Error{Pos: pos, Token: text, Message: fmt.Sprintf("malformed number %q", text)})
+			}
+			p.tokens = append(p.tokens, token{tokenNumber, text, Span{pos, pos + len(text)}})
+		case isLetter(c):
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokenIdent, src[start:i], Span{pos, pos + i - start}})
+		case c == '*' && i+1 < len(src) && src[i+1] == '*':
+			// "**" is an alias for "^"
+			i += 2
+			p.tokens = append(p.tokens, token{tokenOperator, "^", Span{pos, pos + 2}})
+		case strings.IndexByte("+-*/^", c) >= 0:
+			i++
+			p.tokens = append(p.tokens, token{tokenOperator, string(c), Span{pos, pos + 1}})
+		case c == '(':
+			i++
+			p.tokens = append(p.tokens, token{tokenLParen, "(", Span{pos, pos + 1}})
+		case c == ')':
+			i++
+			p.tokens = append(p.tokens, token{tokenRParen, ")", Span{pos, pos + 1}})
+		case c == ',':
+			i++
+			p.tokens = append(p.tokens, token{tokenComma, ",", Span{pos, pos + 1}})
+		default:
+			r, size := utf8.DecodeRuneInString(src[i:])
+			p.errors = append(p.errors, &Error{Pos: pos, Token: string(r), Message: fmt.Sprintf("invalid character %q", r)})
+			i += size
+			pos++
+			continue
+		}
+		// Every other token is ASCII, one byte per character
+		pos += i - start
+	}
+	p.tokens = append(p.tokens, token{tokenEOF, "", Span{pos, pos}})
+}
+
+// peek returns the current token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isOperator checks if the current token is one of the given operators
+func (p *parser) isOperator(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// enter goes one level deeper into the expression. Past maxDepth it reports an
+// error and skips the rest of the input, which could only be nested deeper still.
+func (p *parser) enter() bool {
+	if p.tooDeep {
+		return false
+	}
+	if p.depth == maxDepth {
+		p.errorf(p.peek(), "", "expression nested deeper than %d levels", maxDepth)
+		p.tooDeep = true
+		p.pos = len(p.tokens) - 1
+		return false
+	}
+	p.depth++
+	return true
+}
+
+// leave returns from a level entered with enter
+func (p *parser) leave() {
+	p.depth--
+}
+
+// errorf records a syntax error at the given token together with what was expected in its place.
+// Once the input is given up for nesting too deeply the errors of the enclosing levels are dropped.
+func (p *parser) errorf(t token, expected, format string, args ...interface{}) {
+	if p.tooDeep {
+		return
+	}
+	p.errors = append(p.errors, &Error{
+		Pos:      t.span.Start,
+		Token:    t.text,
+		Expected: expected,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *parser) parseExpr() Node {
+	left := p.parseTerm()
+	for p.isOperator("+", "-") {
+		op := p.next()
+		right := p.parseTerm()
+		left = &BinaryOp{Op: op.text, Left: left, Right: right, Span: Span{left.Pos().Start, right.Pos().End}}
+	}
+	return left
+}
+
+func (p *parser) parseTerm() Node {
+	left := p.parseUnary()
+	for p.isOperator("*", "/") {
+		op := p.next()
+		right := p.parseUnary()
+		left = &BinaryOp{Op: op.text, Left: left, Right: right, Span: Span{left.Pos().Start, right.Pos().End}}
+	}
+	return left
+}
+
+func (p *parser) parseUnary() Node {
+	// Every operand, parenthesized expression and call argument goes through
+	// here, so this is where the recursion is bounded
+	if !p.enter() {
+		return &BadExpr{Span: p.peek().span}
+	}
+	defer p.leave()
+
+	if p.isOperator("+", "-") {
+		op := p.next()
+		operand := p.parseUnary()
+		return &UnaryOp{Op: op.text, Operand: operand, Span: Span{op.span.Start, operand.Pos().End}}
+	}
+	return p.parsePower()
+}
+
+func (p *parser) parsePower() Node {
+	base := p.parsePrimary()
+	if p.isOperator("^") {
+		op := p.next()
+		// The exponent may itself be signed or another power, which makes "^" right-associative
+		exp := p.parseUnary()
+		return &BinaryOp{Op: op.text, Left: base, Right: exp, Span: Span{base.Pos().Start, exp.Pos().End}}
+	}
+	return base
+}
+
+func (p *parser) parsePrimary() Node {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		return &Number{Value: t.text, Span: t.span}
+	case tokenIdent:
+		return p.parseCall()
+	case tokenLParen:
+		p.next()
+		inner := p.parseExpr()
+		p.expectClose(t)
+		// Parentheses only group, the inner node keeps its own span
+		return inner
+	}
+
+	p.errorf(t, "number, function or (", "unexpected %s, expected number, function or (", t.describe())
+	// A stray operator is skipped and the operand after it is parsed as usual,
+	// anything else may close an enclosing construct and is left in place
+	if t.kind == tokenOperator {
+		p.next()
+		return p.parseUnary()
+	}
+	return &BadExpr{Span: t.span}
+}
+
+func (p *parser) parseCall() Node {
+	name := p.next()
+	if p.peek().kind != tokenLParen {
+		p.errorf(name, "(", "unexpected %s, expected ( after function %s", p.peek().describe(), name.text)
+		return &BadExpr{Span: name.span}
+	}
+	open := p.next()
+
+	call := &Call{Name: strings.ToLower(name.text)}
+	if p.peek().kind != tokenRParen {
+		call.Args = append(call.Args, p.parseExpr())
+		for p.peek().kind == tokenComma {
+			p.next()
+			call.Args = append(call.Args, p.parseExpr())
+		}
+	}
+
+	end := p.expectClose(open)
+	if end < 0 {
+		end = p.peek().span.Start
+	}
+	call.Span = Span{name.span.Start, end}
+	return call
+}
+
+// expectClose consumes the ")" matching open and returns its end offset,
+// or reports the mismatch and returns -1
+func (p *parser) expectClose(open token) int {
+	t := p.peek()
+	if t.kind == tokenRParen {
+		p.next()
+		return t.span.End
+	}
+	p.errorf(t, ")", "unexpected %s, expected ) to match ( at position %d", t.describe(), open.span.Start)
+	return -1
+}
+
+// scanExponent returns the length of the exponent at the start of src, like "e-9"
+// or "E3", or 0 if there is none. An "e" without digits is consumed as well and
+// reported as not valid, so that "2e" is a malformed number.
+func scanExponent(src string) (int, bool) {
+	if len(src) == 0 || src[0] != 'e' && src[0] != 'E' {
+		return 0, true
+	}
+	n := 1
+	if n < len(src) && (src[n] == '+' || src[n] == '-') {
+		n++
+	}
+	digits := n
+	for n < len(src) && isDigit(src[n]) {
+		n++
+	}
+	return n, n > digits
+}
+
+// isLetter checks if a byte can start a function name
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// isDigit checks if a byte is a decimal digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
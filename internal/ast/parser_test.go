@@ -0,0 +1,171 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"2+2":             "(2 + 2)",
+		"2+2*2":           "(2 + (2 * 2))",
+		"8/4/2":           "((8 / 4) / 2)",
+		"(1 + 2) * 3":     "((1 + 2) * 3)",
+		"-5+3":            "((-5) + 3)",
+		"2*(-3)":          "(2 * (-3))",
+		"--1":             "(-(-1))",
+		"+4":              "(+4)",
+		"2^3^2":           "(2 ^ (3 ^ 2))",
+		"2**3":            "(2 ^ 3)",
+		"-2^2":            "(-(2 ^ 2))",
+		"2^-1":            "(2 ^ (-1))",
+		"(2^3)^2":         "((2 ^ 3) ^ 2)",
+		"sqrt(4)":         "sqrt(4)",
+		"max(1,2+3,4)":    "max(1, (2 + 3), 4)",
+		"COS(0)":          "cos(0)",
+		"round(sin(1),2)": "round(sin(1), 2)",
+		"2*abs(-1)":       "(2 * abs((-1)))",
+		"log(8,2)^2":      "(log(8, 2) ^ 2)",
+		"f()":             "f()",
+		"1e-9":            "1e-9",
+		"2.5E3*2":         "(2.5E3 * 2)",
+		"1e+21-.5e2":      "(1e+21 - .5e2)",
+		"2^1e1":           "(2 ^ 1e1)",
+	}
+
+	for src, expected := range cases {
+		node, err := Parse(src)
+		assert.NoError(t, err, src)
+		assert.Equal(t, expected, node.String(), src)
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	node, err := Parse("12 + sqrt(4)")
+	assert.NoError(t, err)
+
+	bin := node.(*BinaryOp)
+	assert.Equal(t, Span{0, 12}, bin.Pos())
+	assert.Equal(t, Span{0, 2}, bin.Left.Pos())
+	assert.Equal(t, Span{5, 12}, bin.Right.Pos())
+	assert.Equal(t, Span{10, 11}, bin.Right.(*Call).Args[0].Pos())
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string][]int{
+		"2+*2":     {2},
+		"(1+2":     {4},
+		"1+2)":     {3},
+		"2+x":      {2},
+		"1.2.3":    {0},
+		"2 # 3":    {2, 4},
+		"1,2":      {1},
+		"max(1,)":  {6},
+		"()":       {1},
+		"":         {0},
+		"2+ & * 3": {3, 5},
+		"(1+ )*(2": {4, 8},
+		"2e":       {0},
+		"1+2E-":    {2},
+		"1.2.3e4":  {0},
+		".e5":      {0},
+	}
+
+	for src, positions := range cases {
+		_, err := Parse(src)
+		if !assert.Error(t, err, src) {
+			continue
+		}
+		errs := err.(ErrorList)
+		var got []int
+		for _, e := range errs {
+			got = append(got, e.Pos)
+		}
+		assert.Equal(t, positions, got, src)
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	src := "1 + foo"
+	_, err := Parse(src)
+	errs := err.(ErrorList)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, 4, errs[0].Pos)
+		assert.Equal(t, "foo", errs[0].Token)
+		assert.Equal(t, "(", errs[0].Expected)
+		assert.Equal(t, "1 + foo\n    ^~~", errs[0].Snippet(src))
+	}
+}
+
+func TestInspect(t *testing.T) {
+	node, err := Parse("max(1, -2) * 3")
+	assert.NoError(t, err)
+
+	var kinds []string
+	Inspect(node, func(n Node) bool {
+		switch n.(type) {
+		case *Number:
+			kinds = append(kinds, "number")
+		case *UnaryOp:
+			kinds = append(kinds, "unary")
+		case *BinaryOp:
+			kinds = append(kinds, "binary")
+		case *Call:
+			kinds = append(kinds, "call")
+		}
+		return true
+	})
+	assert.Equal(t, []string{"binary", "call", "number", "unary", "number", "number"}, kinds)
+}
+
+func TestParseMultibyte(t *testing.T) {
+	// Positions count characters, not bytes: "×", "²", "√" and "π" take two or three bytes
+	cases := map[string][]int{
+		"2 × 3 + x²":  {2, 4, 8, 9},
+		"√4 + (1":     {0, 7},
+		"π*2 + 1.2.3": {0, 1, 6},
+	}
+
+	for src, positions := range cases {
+		_, err := Parse(src)
+		if !assert.Error(t, err, src) {
+			continue
+		}
+		var got []int
+		for _, e := range err.(ErrorList) {
+			got = append(got, e.Pos)
+		}
+		assert.Equal(t, positions, got, src)
+	}
+
+	_, err := Parse("2 × 3")
+	errs := err.(ErrorList)
+	assert.Equal(t, "×", errs[0].Token)
+	assert.Equal(t, "2 × 3\n  ^", errs[0].Snippet("2 × 3"))
+	assert.Equal(t, "2 × 3\n    ^", errs[1].Snippet("2 × 3"))
+}
+
+func TestParseDepthLimit(t *testing.T) {
+	// Nesting up to the limit parses
+	_, err := Parse(strings.Repeat("(", maxDepth-1) + "1" + strings.Repeat(")", maxDepth-1))
+	assert.NoError(t, err)
+	_, err = Parse(strings.Repeat("-", maxDepth-1) + "1")
+	assert.NoError(t, err)
+
+	// Deeper input is rejected with a single error instead of overflowing the stack
+	for _, src := range []string{
+		strings.Repeat("(", 1000000),
+		strings.Repeat("-", 1000000) + "1",
+		strings.Repeat("2^", 1000000) + "2",
+		strings.Repeat("abs(", 1000000),
+	} {
+		_, err := Parse(src)
+		if assert.Error(t, err) {
+			errs := err.(ErrorList)
+			assert.Len(t, errs, 1)
+			assert.Contains(t, errs[0].Message, "nested deeper")
+		}
+	}
+}
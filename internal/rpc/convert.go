@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/w0ikid/megacalc/internal/rpc/taskpb"
+	"github.com/w0ikid/megacalc/internal/service"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TaskToProto converts a task into its protocol message
+func TaskToProto(task *service.Task) *taskpb.Task {
+	args := make([]*taskpb.Operand, len(task.Args))
+	for i, arg := range task.Args {
+		args[i] = &taskpb.Operand{Ref: arg.Ref, Value: string(arg.Value)}
+	}
+
+	msg := &taskpb.Task{
+		Id:            task.ID,
+		ExpressionId:  task.ExpressionID,
+		Args:          args,
+		Operation:     string(task.Operation),
+		OperationTime: int32(task.OperationTime),
+		Mode:          string(task.Mode),
+		Precision:     int32(task.Precision),
+		LeaseId:       task.LeaseID,
+		Attempts:      int32(task.Attempts),
+		AgentId:       task.AgentID,
+	}
+	if !task.LeaseDeadline.IsZero() {
+		msg.LeaseDeadline = timestamppb.New(task.LeaseDeadline)
+	}
+	return msg
+}
+
+// RegistrationFromProto converts the registration message of an agent
+func RegistrationFromProto(msg *taskpb.Register) service.AgentRegistration {
+	ops := make([]service.Operation, len(msg.GetOperations()))
+	for i, op := range msg.GetOperations() {
+		ops[i] = service.Operation(op)
+	}
+	var affinity map[service.Operation]int
+	if len(msg.GetAffinity()) > 0 {
+		affinity = make(map[service.Operation]int)
+		for op, weight := range msg.GetAffinity() {
+			affinity[service.Operation(op)] = int(weight)
+		}
+	}
+	return service.AgentRegistration{
+		ID:             msg.GetId(),
+		Hostname:       msg.GetHostname(),
+		ComputingPower: int(msg.GetComputingPower()),
+		Operations:     ops,
+		Affinity:       affinity,
+		Version:        msg.GetVersion(),
+	}
+}
+
+// TaskFromProto converts a protocol message into a task
+func TaskFromProto(msg *taskpb.Task) *service.Task {
+	args := make([]service.Operand, len(msg.GetArgs()))
+	for i, arg := range msg.GetArgs() {
+		args[i] = service.Operand{Ref: arg.GetRef(), Value: service.Value(arg.GetValue())}
+	}
+
+	task := &service.Task{
+		ID:            msg.GetId(),
+		ExpressionID:  msg.GetExpressionId(),
+		Args:          args,
+		Operation:     service.Operation(msg.GetOperation()),
+		OperationTime: int(msg.GetOperationTime()),
+		Status:        "processing",
+		Mode:          service.NumericMode(msg.GetMode()),
+		Precision:     int(msg.GetPrecision()),
+		LeaseID:       msg.GetLeaseId(),
+		Attempts:      int(msg.GetAttempts()),
+		AgentID:       msg.GetAgentId(),
+	}
+	if msg.LeaseDeadline != nil {
+		task.LeaseDeadline = msg.GetLeaseDeadline().AsTime().In(time.Local)
+	}
+	return task
+}
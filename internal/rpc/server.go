@@ -0,0 +1,182 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/w0ikid/megacalc/internal/rpc/taskpb"
+	"github.com/w0ikid/megacalc/internal/service"
+	"google.golang.org/grpc"
+)
+
+// Server serves the TaskService protocol to agents on top of a Service
+type Server struct {
+	taskpb.UnimplementedTaskServiceServer
+	service *service.Service
+}
+
+// NewServer creates a new TaskService server
+func NewServer(svc *service.Service) *Server {
+	return &Server{
+		service: svc,
+	}
+}
+
+// Serve accepts agent connections on the listener until it fails
+func (s *Server) Serve(lis net.Listener) error {
+	gs := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(gs, s)
+	return gs.Serve(lis)
+}
+
+// Connect pushes tasks to an agent as long as it has granted credits
+// and applies the results and errors it streams back
+func (s *Server) Connect(stream taskpb.TaskService_ConnectServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	conn := &connection{stream: stream, credits: make(chan struct{}, 1)}
+	go func() {
+		defer cancel()
+		if err := s.receive(conn); err != nil {
+			log.Printf("Agent stream closed: %v", err)
+		}
+	}()
+
+	for conn.take(ctx) {
+		task, found := s.service.WaitForTask(ctx, service.TaskRequest{AgentID: conn.agent()})
+		if !found {
+			break
+		}
+		if err := conn.send(&taskpb.OrchestratorMessage{
+			Message: &taskpb.OrchestratorMessage_Task{Task: TaskToProto(task)},
+		}); err != nil {
+			// The lease expires and the task is handed out again
+			return err
+		}
+	}
+	return nil
+}
+
+// receive handles the messages of an agent until the stream ends
+func (s *Server) receive(conn *connection) error {
+	for {
+		msg, err := conn.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.Message.(type) {
+		case *taskpb.AgentMessage_Register:
+			agent := s.service.RegisterAgent(RegistrationFromProto(m.Register))
+			conn.identify(agent.ID)
+			log.Printf("Agent %s registered from %s", agent.ID, agent.Hostname)
+		case *taskpb.AgentMessage_Heartbeat:
+			if id := conn.agent(); id != "" {
+				err = s.service.Heartbeat(id)
+			}
+		case *taskpb.AgentMessage_Ready:
+			conn.grant(m.Ready.GetCredits())
+		case *taskpb.AgentMessage_Result:
+			err = s.service.SetTaskResult(m.Result.GetId(), m.Result.GetLeaseId(), service.Value(m.Result.GetResult()))
+			err = conn.reject(m.Result.GetId(), err)
+		case *taskpb.AgentMessage_Error:
+			err = s.service.SetTaskError(m.Error.GetId(), m.Error.GetLeaseId(), m.Error.GetCode(), m.Error.GetMessage())
+			err = conn.reject(m.Error.GetId(), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// connection is the state of one agent stream
+type connection struct {
+	stream  taskpb.TaskService_ConnectServer
+	sendMu  sync.Mutex
+	mu      sync.Mutex
+	agentID string
+	n       int32
+	credits chan struct{}
+}
+
+// identify remembers the agent registered on the stream
+func (c *connection) identify(agentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agentID = agentID
+}
+
+// agent returns the ID of the agent registered on the stream, if any
+func (c *connection) agent() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.agentID
+}
+
+// grant adds credits and wakes up the sender
+func (c *connection) grant(n int32) {
+	c.mu.Lock()
+	c.n += n
+	c.mu.Unlock()
+
+	select {
+	case c.credits <- struct{}{}:
+	default:
+	}
+}
+
+// take uses up one credit, waiting for the agent to grant one until the context is done
+func (c *connection) take(ctx context.Context) bool {
+	for {
+		c.mu.Lock()
+		if c.n > 0 {
+			c.n--
+			c.mu.Unlock()
+			return true
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.credits:
+		}
+	}
+}
+
+// reject tells the agent that its report for a task was not accepted
+func (c *connection) reject(taskID string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := "invalid"
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		code = "not_found"
+	case errors.Is(err, service.ErrStaleLease):
+		code = "stale_lease"
+	}
+	return c.send(&taskpb.OrchestratorMessage{
+		Message: &taskpb.OrchestratorMessage_Rejected{Rejected: &taskpb.Rejected{
+			TaskId:  taskID,
+			Code:    code,
+			Message: err.Error(),
+		}},
+	})
+}
+
+// send writes a message, streams are not safe for concurrent sends
+func (c *connection) send(msg *taskpb.OrchestratorMessage) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.stream.Send(msg)
+}
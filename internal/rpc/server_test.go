@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w0ikid/megacalc/internal/rpc/taskpb"
+	"github.com/w0ikid/megacalc/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func connect(t *testing.T, svc *service.Service) taskpb.TaskService_ConnectClient {
+	lis := bufconn.Listen(1 << 20)
+	go NewServer(svc).Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		lis.Close()
+	})
+
+	stream, err := taskpb.NewTaskServiceClient(conn).Connect(ctx)
+	assert.NoError(t, err)
+	return stream
+}
+
+func TestServerConnect(t *testing.T) {
+	svc := service.NewService(service.OperationTimes{})
+	exprID, _ := svc.SubmitExpression("(1+2)*3")
+	stream := connect(t, svc)
+
+	assert.NoError(t, stream.Send(&taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Register{Register: &taskpb.Register{Id: "agent-1", ComputingPower: 2}},
+	}))
+
+	// Tasks are only pushed once the agent grants credits
+	assert.NoError(t, stream.Send(&taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Ready{Ready: &taskpb.Ready{Credits: 2}},
+	}))
+
+	for i := 0; i < 2; i++ {
+		msg, err := stream.Recv()
+		assert.NoError(t, err)
+		task := TaskFromProto(msg.GetTask())
+		assert.NotEmpty(t, task.LeaseID)
+		assert.Equal(t, "agent-1", task.AgentID)
+
+		result, err := service.ProcessTask(task)
+		assert.NoError(t, err)
+		assert.NoError(t, stream.Send(&taskpb.AgentMessage{
+			Message: &taskpb.AgentMessage_Result{Result: &taskpb.TaskResult{
+				Id:      task.ID,
+				LeaseId: task.LeaseID,
+				Result:  string(result),
+			}},
+		}))
+	}
+
+	// A duplicate report is rejected
+	assert.NoError(t, stream.Send(&taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Result{Result: &taskpb.TaskResult{Id: "task_1", LeaseId: "lease", Result: "3"}},
+	}))
+	msg, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "stale_lease", msg.GetRejected().GetCode())
+
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, service.Completed, expr.Status)
+	assert.Equal(t, "9", expr.Value)
+
+	agents := svc.GetAgents()
+	assert.Len(t, agents, 1)
+	assert.Equal(t, 2, agents[0].TasksCompleted)
+}
+
+func TestTaskProtoRoundTrip(t *testing.T) {
+	task := &service.Task{
+		ID:            "task_1",
+		ExpressionID:  "expr",
+		Args:          []service.Operand{{Ref: "task_0", Value: "1/3"}, {Value: "2"}},
+		Operation:     service.Division,
+		OperationTime: 100,
+		Status:        "processing",
+		Mode:          service.RationalMode,
+		AgentID:       "agent-1",
+		LeaseID:       "lease",
+		LeaseDeadline: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		Attempts:      1,
+	}
+	assert.Equal(t, task, TaskFromProto(TaskToProto(task)))
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AgentStatus represents the liveness of a registered agent
+type AgentStatus string
+
+const (
+	AgentAlive AgentStatus = "alive"
+	AgentDead  AgentStatus = "dead"
+)
+
+// DefaultHeartbeatTimeout is how long an agent may stay silent before it is considered dead
+const DefaultHeartbeatTimeout = 15 * time.Second
+
+// DefaultAgentRemoveAfter is how long a dead agent stays in the registry before it is removed
+const DefaultAgentRemoveAfter = 10 * time.Minute
+
+// ErrAgentNotFound is returned for heartbeats of an agent that never registered
+var ErrAgentNotFound = errors.New("agent not found")
+
+// AgentRegistration is what an agent reports about itself when it registers.
+// An empty ID lets the orchestrator choose one.
+type AgentRegistration struct {
+	ID             string
+	Hostname       string
+	ComputingPower int
+	Operations     []Operation
+	Affinity       map[Operation]int
+	Version        string
+}
+
+// AgentInfo is the state of a registered agent
+type AgentInfo struct {
+	ID             string            `json:"id"`
+	Hostname       string            `json:"hostname"`
+	ComputingPower int               `json:"computing_power"`
+	Operations     []Operation       `json:"operations"`
+	Affinity       map[Operation]int `json:"affinity,omitempty"`
+	Version        string            `json:"version"`
+	Status         AgentStatus       `json:"status"`
+	RegisteredAt   time.Time         `json:"registered_at"`
+	LastHeartbeat  time.Time         `json:"last_heartbeat"`
+	TasksInFlight  int               `json:"tasks_in_flight"`
+	TasksCompleted int               `json:"tasks_completed"`
+}
+
+// AgentRegistry keeps track of the agents known to the orchestrator.
+// It is guarded by the lock of the Service that owns it.
+type AgentRegistry struct {
+	agents      map[string]*AgentInfo
+	timeout     time.Duration
+	removeAfter time.Duration
+}
+
+// NewAgentRegistry creates a registry that marks agents dead after timeout without a heartbeat
+// and removes them once they have been dead for DefaultAgentRemoveAfter
+func NewAgentRegistry(timeout time.Duration) *AgentRegistry {
+	return &AgentRegistry{
+		agents:      make(map[string]*AgentInfo),
+		timeout:     timeout,
+		removeAfter: DefaultAgentRemoveAfter,
+	}
+}
+
+// register adds an agent or updates the details of a known one
+func (r *AgentRegistry) register(reg AgentRegistration, now time.Time) *AgentInfo {
+	agent, ok := r.agents[reg.ID]
+	if !ok {
+		agent = &AgentInfo{ID: reg.ID, RegisteredAt: now}
+		r.agents[reg.ID] = agent
+	}
+	agent.Hostname = reg.Hostname
+	agent.ComputingPower = reg.ComputingPower
+	agent.Operations = reg.Operations
+	agent.Affinity = reg.Affinity
+	agent.Version = reg.Version
+	agent.Status = AgentAlive
+	agent.LastHeartbeat = now
+	return agent
+}
+
+// touch records a sign of life of an agent, reviving it if it was considered dead
+func (r *AgentRegistry) touch(id string, now time.Time) bool {
+	agent, ok := r.agents[id]
+	if !ok {
+		return false
+	}
+	if agent.Status == AgentDead {
+		log.Printf("Agent %s is back", id)
+	}
+	agent.Status = AgentAlive
+	agent.LastHeartbeat = now
+	return true
+}
+
+// capabilities fills in the operations and affinity a registered agent did not repeat in its request
+func (r *AgentRegistry) capabilities(req TaskRequest) TaskRequest {
+	agent, ok := r.agents[req.AgentID]
+	if !ok || len(req.Operations) > 0 {
+		return req
+	}
+	req.Operations = agent.Operations
+	if req.Affinity == nil {
+		req.Affinity = agent.Affinity
+	}
+	return req
+}
+
+// completed counts a task result reported by an agent
+func (r *AgentRegistry) completed(id string) {
+	if agent, ok := r.agents[id]; ok {
+		agent.TasksCompleted++
+	}
+}
+
+// expire marks agents without a recent heartbeat dead and returns their IDs.
+// Agents that stayed dead for removeAfter are removed, zero keeps them forever.
+func (r *AgentRegistry) expire(now time.Time) map[string]bool {
+	dead := make(map[string]bool)
+	for id, agent := range r.agents {
+		silence := now.Sub(agent.LastHeartbeat)
+		if agent.Status == AgentAlive && silence > r.timeout {
+			log.Printf("Agent %s missed its heartbeats since %s, marking it dead", id, agent.LastHeartbeat.Format(time.RFC3339))
+			agent.Status = AgentDead
+			dead[id] = true
+		}
+		if agent.Status == AgentDead && r.removeAfter > 0 && silence > r.timeout+r.removeAfter {
+			log.Printf("Agent %s has been dead for %s, removing it", id, r.removeAfter)
+			delete(r.agents, id)
+		}
+	}
+	return dead
+}
+
+// ParseOperations checks a list of operation and function names against the supported ones
+func ParseOperations(names []string) ([]Operation, error) {
+	supported := make(map[Operation]bool)
+	for _, op := range SupportedOperations() {
+		supported[op] = true
+	}
+
+	var ops []Operation
+	for _, name := range names {
+		op := Operation(strings.ToLower(strings.TrimSpace(name)))
+		if !supported[op] {
+			return nil, fmt.Errorf("unsupported operation: %s", name)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// ParseAffinity parses affinities written as "operation:weight", e.g. "sqrt:10"
+func ParseAffinity(entries []string) (map[Operation]int, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	affinity := make(map[Operation]int)
+	for _, entry := range entries {
+		name, weight, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid affinity %q, expected operation:weight", entry)
+		}
+		ops, err := ParseOperations([]string{name})
+		if err != nil {
+			return nil, err
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil {
+			return nil, fmt.Errorf("invalid affinity weight %q for %s", weight, name)
+		}
+		affinity[ops[0]] = w
+	}
+	return affinity, nil
+}
+
+// RegisterAgent registers an agent and returns its state
+func (s *Service) RegisterAgent(reg AgentRegistration) AgentInfo {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if reg.ID == "" {
+		reg.ID = uuid.New().String()
+	}
+	return *s.agents.register(reg, s.now())
+}
+
+// Heartbeat records that an agent is alive. Unknown agents have to register first.
+func (s *Service) Heartbeat(agentID string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	if !s.agents.touch(agentID, s.now()) {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	return nil
+}
+
+// GetAgents returns all registered agents ordered by ID
+func (s *Service) GetAgents() []AgentInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inFlight := make(map[string]int)
+	for _, task := range s.tasks {
+		if task.AgentID != "" {
+			inFlight[task.AgentID]++
+		}
+	}
+
+	result := make([]AgentInfo, 0, len(s.agents.agents))
+	for _, agent := range s.agents.agents {
+		info := *agent
+		info.TasksInFlight = inFlight[agent.ID]
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// ReapDeadAgents marks agents that missed their heartbeats dead and returns the
+// tasks they hold to the ready set without waiting for the leases to expire.
+// Agents dead for longer than the removal grace period are removed from the
+// registry. It returns the number of requeued tasks.
+func (s *Service) ReapDeadAgents() int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	dead := s.agents.expire(s.now())
+	if len(dead) == 0 {
+		return 0
+	}
+
+	requeued := 0
+	for taskID, task := range s.tasks {
+		if task.LeaseID == "" || !dead[task.AgentID] || s.completedTasks[taskID] {
+			continue
+		}
+
+		log.Printf("Reclaiming task %s from dead agent %s", taskID, task.AgentID)
+		s.requeueTask(task, "agent dead")
+		requeued++
+	}
+	return requeued
+}
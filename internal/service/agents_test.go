@@ -0,0 +1,158 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceAgentRegistry(t *testing.T) {
+	svc := NewService(OperationTimes{}, WithHeartbeatTimeout(10*time.Second))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	agent := svc.RegisterAgent(AgentRegistration{
+		ID:             "agent-1",
+		Hostname:       "host",
+		ComputingPower: 4,
+		Operations:     []Operation{Addition},
+		Version:        "1.0.0",
+	})
+	assert.Equal(t, AgentAlive, agent.Status)
+	assert.Equal(t, now, agent.RegisteredAt)
+
+	// An ID is assigned to agents that do not bring one
+	other := svc.RegisterAgent(AgentRegistration{Hostname: "other"})
+	assert.NotEmpty(t, other.ID)
+
+	assert.ErrorIs(t, svc.Heartbeat("unknown"), ErrAgentNotFound)
+
+	exprID, _ := svc.SubmitExpression("2+2")
+	task, found := svc.AssignTask(TaskRequest{AgentID: "agent-1"})
+	assert.True(t, found)
+	assert.Equal(t, "agent-1", task.AgentID)
+
+	agents := svc.GetAgents()
+	assert.Len(t, agents, 2)
+	for _, a := range agents {
+		if a.ID == "agent-1" {
+			assert.Equal(t, 1, a.TasksInFlight)
+		}
+	}
+
+	// The other agent keeps sending heartbeats, agent-1 goes silent
+	now = now.Add(8 * time.Second)
+	assert.NoError(t, svc.Heartbeat(other.ID))
+	now = now.Add(8 * time.Second)
+	assert.Equal(t, 1, svc.ReapDeadAgents())
+
+	agents = svc.GetAgents()
+	for _, a := range agents {
+		if a.ID == "agent-1" {
+			assert.Equal(t, AgentDead, a.Status)
+			assert.Equal(t, 0, a.TasksInFlight)
+		} else {
+			assert.Equal(t, AgentAlive, a.Status)
+		}
+	}
+
+	// The task of the dead agent is handed out again before its lease expires
+	retry, found := svc.AssignTask(TaskRequest{AgentID: other.ID})
+	assert.True(t, found)
+	assert.Equal(t, task.ID, retry.ID)
+	assert.ErrorIs(t, svc.SetTaskResult(task.ID, task.LeaseID, "4"), ErrStaleLease)
+	assert.NoError(t, svc.SetTaskResult(retry.ID, retry.LeaseID, "4"))
+
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, Completed, expr.Status)
+
+	// A dead agent that sends a heartbeat is alive again
+	assert.NoError(t, svc.Heartbeat("agent-1"))
+	for _, a := range svc.GetAgents() {
+		assert.Equal(t, AgentAlive, a.Status)
+		if a.ID == other.ID {
+			assert.Equal(t, 1, a.TasksCompleted)
+		}
+	}
+}
+
+func TestServiceRemoveDeadAgents(t *testing.T) {
+	svc := NewService(OperationTimes{}, WithHeartbeatTimeout(10*time.Second), WithAgentRemoveAfter(time.Minute))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	svc.RegisterAgent(AgentRegistration{ID: "agent-1"})
+	svc.RegisterAgent(AgentRegistration{ID: "agent-2"})
+
+	// A dead agent is still listed during the grace period
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, svc.Heartbeat("agent-2"))
+	svc.ReapDeadAgents()
+	assert.Len(t, svc.GetAgents(), 2)
+
+	now = now.Add(41 * time.Second)
+	assert.NoError(t, svc.Heartbeat("agent-2"))
+	svc.ReapDeadAgents()
+	agents := svc.GetAgents()
+	if assert.Len(t, agents, 1) {
+		assert.Equal(t, "agent-2", agents[0].ID)
+	}
+
+	// A removed agent has to register again
+	assert.ErrorIs(t, svc.Heartbeat("agent-1"), ErrAgentNotFound)
+	svc.RegisterAgent(AgentRegistration{ID: "agent-1"})
+	assert.Len(t, svc.GetAgents(), 2)
+
+	// Without a grace period dead agents are kept
+	keep := NewService(OperationTimes{}, WithHeartbeatTimeout(10*time.Second), WithAgentRemoveAfter(0))
+	keep.now = func() time.Time { return now }
+	keep.RegisterAgent(AgentRegistration{ID: "agent-1"})
+	now = now.Add(24 * time.Hour)
+	keep.ReapDeadAgents()
+	if agents := keep.GetAgents(); assert.Len(t, agents, 1) {
+		assert.Equal(t, AgentDead, agents[0].Status)
+	}
+}
+
+func TestServiceCapabilityRouting(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	svc.SubmitExpression("sqrt(16)+2*3")
+
+	// An agent that can only add gets nothing until the operands are ready
+	_, found := svc.AssignTask(TaskRequest{Operations: []Operation{Addition}})
+	assert.False(t, found)
+
+	// Affinity decides between the ready tasks
+	task, found := svc.AssignTask(TaskRequest{Affinity: map[Operation]int{"sqrt": 10}})
+	assert.True(t, found)
+	assert.Equal(t, Operation("sqrt"), task.Operation)
+
+	// Registered agents are routed by the operations they registered with
+	svc.RegisterAgent(AgentRegistration{ID: "adder", Operations: []Operation{Addition, Subtraction}})
+	_, found = svc.AssignTask(TaskRequest{AgentID: "adder"})
+	assert.False(t, found)
+
+	task, found = svc.AssignTask(TaskRequest{Operations: []Operation{Multiplication}})
+	assert.True(t, found)
+	assert.Equal(t, Multiplication, task.Operation)
+}
+
+func TestParseCapabilities(t *testing.T) {
+	ops, err := ParseOperations([]string{"+", " SQRT "})
+	assert.NoError(t, err)
+	assert.Equal(t, []Operation{Addition, "sqrt"}, ops)
+
+	_, err = ParseOperations([]string{"%"})
+	assert.EqualError(t, err, "unsupported operation: %")
+
+	affinity, err := ParseAffinity([]string{"sqrt:10", "^:5"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[Operation]int{"sqrt": 10, Exponentiation: 5}, affinity)
+
+	_, err = ParseAffinity([]string{"sqrt"})
+	assert.Error(t, err)
+	_, err = ParseAffinity([]string{"sqrt:high"})
+	assert.Error(t, err)
+}
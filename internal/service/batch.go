@@ -0,0 +1,46 @@
+package service
+
+import "github.com/w0ikid/megacalc/internal/ast"
+
+// BatchItem is an expression of a batch submission with its options
+type BatchItem struct {
+	Expression string
+	Options    ExpressionOptions
+}
+
+// BatchResult is the outcome of a batch item: the ID of the new expression,
+// or the error the expression was rejected with
+type BatchResult struct {
+	ID  string
+	Err error
+}
+
+// SubmitBatch adds the expressions of a batch under a single lock. Every item is
+// parsed before the lock is taken, so a large batch does not hold up agents.
+// If atomic is set and an item has an error, no expression is added and only
+// the invalid items have a result with an error. Otherwise the valid items are
+// added, the invalid ones are stored as failed just like a single submission,
+// and the results keep the order of the items.
+func (s *Service) SubmitBatch(items []BatchItem, atomic bool) []BatchResult {
+	results := make([]BatchResult, len(items))
+	roots := make([]ast.Node, len(items))
+	valid := true
+	for i, item := range items {
+		roots[i], results[i].Err = parseTree(item.Expression, s.expressionMode(item.Options))
+		valid = valid && results[i].Err == nil
+	}
+	if atomic && !valid {
+		return results
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	for i, item := range items {
+		id := s.addExpression(item.Expression, item.Options, roots[i], results[i].Err)
+		if results[i].Err == nil {
+			results[i].ID = id
+		}
+	}
+	return results
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w0ikid/megacalc/internal/ast"
+)
+
+func TestServiceSubmitBatch(t *testing.T) {
+	svc := NewService(OperationTimes{})
+	items := []BatchItem{
+		{Expression: "1+2"},
+		{Expression: "2*(3"},
+		{Expression: "7", Options: ExpressionOptions{Mode: DecimalMode}},
+	}
+
+	// All or nothing: the invalid item rejects the whole batch
+	results := svc.SubmitBatch(items, true)
+	assert.Len(t, results, 3)
+	assert.Equal(t, BatchResult{}, results[0])
+	var syntaxErrors ast.ErrorList
+	assert.ErrorAs(t, results[1].Err, &syntaxErrors)
+	assert.Equal(t, BatchResult{}, results[2])
+	assert.Empty(t, svc.GetExpressions())
+
+	// Best effort: the valid items are added in order
+	results = svc.SubmitBatch(items, false)
+	assert.NotEmpty(t, results[0].ID)
+	assert.NoError(t, results[0].Err)
+	assert.Empty(t, results[1].ID)
+	assert.Error(t, results[1].Err)
+	assert.NotEmpty(t, results[2].ID)
+
+	expr, _ := svc.GetExpression(results[0].ID)
+	assert.Equal(t, InProcess, expr.Status)
+	expr, _ = svc.GetExpression(results[2].ID)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, DecimalMode, expr.Mode)
+
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, results[0].ID, task.ExpressionID)
+
+	// A valid batch is added as a whole in either mode
+	results = svc.SubmitBatch([]BatchItem{{Expression: "2*3"}, {Expression: "4-1"}}, true)
+	assert.NotEmpty(t, results[0].ID)
+	assert.NotEmpty(t, results[1].ID)
+	assert.Len(t, svc.GetExpressions(), 5)
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"fmt"
+)
+
+// rankTasks computes the upward rank of the tasks task_first..task_last of one
+// expression: the operation time of a task plus the largest rank among the tasks
+// that depend on it. The rank is the length of the longest path from the task to
+// the root, so the largest rank is the critical path of the expression, the time
+// it takes with unlimited agents. Tasks are created children first, so walking
+// them backwards visits every dependent before its dependencies.
+func (s *Service) rankTasks(first, last int) int {
+	criticalPath := 0
+	for i := last; i >= first; i-- {
+		task := s.tasks[fmt.Sprintf("task_%d", i)]
+
+		rank := 0
+		for _, depID := range s.reverseDependencies[task.ID] {
+			if r := s.tasks[depID].Rank; r > rank {
+				rank = r
+			}
+		}
+		task.Rank = task.OperationTime + rank
+
+		if task.Rank > criticalPath {
+			criticalPath = task.Rank
+		}
+	}
+	return criticalPath
+}
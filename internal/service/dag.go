@@ -0,0 +1,133 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskNode is the public view of a task of an expression with its place in the
+// dependency graph: the tasks whose results it takes and the tasks that take its
+// result. AgentID is the agent computing the task or, once it is finished, the
+// agent that reported it. The lease of the task is left out, it would let anyone
+// who can read the graph report a result in the name of the agent computing it.
+type TaskNode struct {
+	ID            string      `json:"id"`
+	ExpressionID  string      `json:"expression_id"`
+	Args          []Operand   `json:"args"`
+	Operation     Operation   `json:"operation"`
+	OperationTime int         `json:"operation_time"`
+	Result        *Value      `json:"result,omitempty"`
+	Status        string      `json:"status"`
+	Mode          NumericMode `json:"mode"`
+	Priority      int         `json:"priority,omitempty"`
+	Rank          int         `json:"rank"`
+	AgentID       string      `json:"agent_id,omitempty"`
+	Attempts      int         `json:"attempts"`
+	DispatchedAt  *time.Time  `json:"dispatched_at,omitempty"`
+	FinishedAt    *time.Time  `json:"finished_at,omitempty"`
+	Dependencies  []string    `json:"dependencies"`
+	Dependents    []string    `json:"dependents"`
+}
+
+// newTaskNode copies the public fields of a task into a node of the graph
+func newTaskNode(task *Task, dependencies, dependents []string) TaskNode {
+	agentID := task.AgentID
+	if agentID == "" {
+		agentID = task.LastAgentID
+	}
+	return TaskNode{
+		ID:            task.ID,
+		ExpressionID:  task.ExpressionID,
+		Args:          append([]Operand{}, task.Args...),
+		Operation:     task.Operation,
+		OperationTime: task.OperationTime,
+		Result:        task.Result,
+		Status:        task.Status,
+		Mode:          task.Mode,
+		Priority:      task.Priority,
+		Rank:          task.Rank,
+		AgentID:       agentID,
+		Attempts:      task.Attempts,
+		DispatchedAt:  task.DispatchedAt,
+		FinishedAt:    task.FinishedAt,
+		Dependencies:  append([]string{}, dependencies...),
+		Dependents:    append([]string{}, dependents...),
+	}
+}
+
+// GetExpressionTasks returns the tasks of an expression in the order they were created
+func (s *Service) GetExpressionTasks(id string) ([]TaskNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.expressions[id]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExpressionNotFound, id)
+	}
+
+	nodes := []TaskNode{}
+	for taskID, task := range s.tasks {
+		if task.ExpressionID != id {
+			continue
+		}
+		nodes = append(nodes, newTaskNode(task, s.dependencyGraph[taskID], s.reverseDependencies[taskID]))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return taskNumber(nodes[i].ID) < taskNumber(nodes[j].ID) })
+	return nodes, nil
+}
+
+// dotColors are the fill colors of tasks in each status
+var dotColors = map[string]string{
+	"pending":    "white",
+	"processing": "gold",
+	"completed":  "palegreen",
+	"failed":     "salmon",
+	"cancelled":  "lightgrey",
+}
+
+// FormatDOT renders the tasks of an expression as a Graphviz digraph. Edges
+// point from a task to the task that takes its result, so the root of the
+// expression is at the bottom, and tasks are colored by their status.
+func FormatDOT(exprID string, nodes []TaskNode) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(exprID))
+	b.WriteString("\tnode [shape=box, style=filled];\n")
+
+	for _, node := range nodes {
+		label := node.ID + "\n" + taskLabel(node.Operation, node.Args)
+		if node.Result != nil {
+			label += "\n= " + string(*node.Result)
+		}
+		if node.AgentID != "" {
+			label += "\nagent " + node.AgentID
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s, fillcolor=%s];\n", strconv.Quote(node.ID), strconv.Quote(label), dotColors[node.Status])
+	}
+	for _, node := range nodes {
+		for _, dep := range node.Dependencies {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", strconv.Quote(dep), strconv.Quote(node.ID))
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// taskLabel writes the operation of a task in infix or call notation
+func taskLabel(operation Operation, operands []Operand) string {
+	args := make([]string, len(operands))
+	for i, arg := range operands {
+		args[i] = arg.String()
+	}
+
+	switch {
+	case operation == Negation && len(args) == 1:
+		return "-" + args[0]
+	case IsFunction(string(operation)):
+		return fmt.Sprintf("%s(%s)", operation, strings.Join(args, ", "))
+	default:
+		return strings.Join(args, " "+string(operation)+" ")
+	}
+}
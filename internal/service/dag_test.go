@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceGetExpressionTasks(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	exprID, _ := svc.SubmitExpression("sqrt(4)*-(1+2)")
+	task, _ := svc.AssignTask(TaskRequest{AgentID: "agent-1"})
+	svc.SetTaskResult(task.ID, task.LeaseID, "2")
+	svc.AssignTask(TaskRequest{AgentID: "agent-2"})
+
+	nodes, err := svc.GetExpressionTasks(exprID)
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 4)
+
+	// Tasks come in creation order with both directions of the graph
+	assert.Equal(t, "completed", nodes[0].Status)
+	assert.NotNil(t, nodes[0].DispatchedAt)
+	assert.NotNil(t, nodes[0].FinishedAt)
+	assert.Equal(t, "agent-1", nodes[0].AgentID)
+	assert.Equal(t, []string{"task_4"}, nodes[0].Dependents)
+	assert.Equal(t, "processing", nodes[1].Status)
+	assert.Equal(t, "agent-2", nodes[1].AgentID)
+	assert.Equal(t, []string{"task_2"}, nodes[2].Dependencies)
+	assert.Equal(t, []string{"task_1", "task_3"}, nodes[3].Dependencies)
+	assert.Empty(t, nodes[3].Dependents)
+
+	dot := FormatDOT(exprID, nodes)
+	assert.Contains(t, dot, `"task_1" [label="task_1\nsqrt(4)\n= 2\nagent agent-1", fillcolor=palegreen];`)
+	assert.Contains(t, dot, `"task_2" [label="task_2\n1 + 2\nagent agent-2", fillcolor=gold];`)
+	assert.Contains(t, dot, `"task_3" [label="task_3\n-task_2", fillcolor=white];`)
+	assert.Contains(t, dot, `"task_4" [label="task_4\n2 * task_3", fillcolor=white];`)
+	assert.Contains(t, dot, `"task_3" -> "task_4";`)
+
+	_, err = svc.GetExpressionTasks("unknown")
+	assert.ErrorIs(t, err, ErrExpressionNotFound)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// FailureTimeout is the error code of expressions that did not complete before their deadline
+const FailureTimeout = "timeout"
+
+// ExpireDeadlines fails every unfinished expression whose deadline has passed,
+// dropping its remaining tasks, and returns the number of expressions failed.
+// Dispatch and results only check the expression of their task, this sweep
+// catches the expressions that wait for neither.
+func (s *Service) ExpireDeadlines() int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	return s.expireDeadlines()
+}
+
+// expireDeadlines fails the expressions past their deadline, the caller must hold the lock
+func (s *Service) expireDeadlines() int {
+	now := s.now()
+	expired := 0
+	for _, expr := range s.expressions {
+		if s.expireDeadline(expr, now) {
+			expired++
+		}
+	}
+	return expired
+}
+
+// expireDeadline fails an unfinished expression whose deadline has passed and
+// reports whether it did, the caller must hold the lock
+func (s *Service) expireDeadline(expr *ExpressionData, now time.Time) bool {
+	if expr == nil || expr.Deadline == nil || expr.Status.Finished() || now.Before(*expr.Deadline) {
+		return false
+	}
+
+	log.Printf("Expression %s missed its deadline %s, failing", expr.ID, expr.Deadline.Format(time.RFC3339))
+	s.failExpression(expr.ID, FailureTimeout, fmt.Sprintf("timeout: not completed by deadline %s", expr.Deadline.Format(time.RFC3339Nano)))
+	return true
+}
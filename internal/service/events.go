@@ -0,0 +1,173 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType names what happened to an expression or one of its tasks
+type EventType string
+
+const (
+	EventTaskDispatched   EventType = "task_dispatched"
+	EventTaskCompleted    EventType = "task_completed"
+	EventTaskFailed       EventType = "task_failed"
+	EventTaskRequeued     EventType = "task_requeued"
+	EventExpressionStatus EventType = "expression_status"
+)
+
+// Event describes a change of an expression or one of its tasks. Seq numbers
+// the events of a service in the order they were published. A completed task
+// or expression has its result, written in the numeric mode of the event.
+type Event struct {
+	Seq          uint64           `json:"seq"`
+	Type         EventType        `json:"type"`
+	Time         time.Time        `json:"time"`
+	ExpressionID string           `json:"expression_id"`
+	Status       ExpressionStatus `json:"status,omitempty"`
+	TaskID       string           `json:"task_id,omitempty"`
+	Operation    Operation        `json:"operation,omitempty"`
+	AgentID      string           `json:"agent_id,omitempty"`
+	Result       Value            `json:"result,omitempty"`
+	Mode         NumericMode      `json:"mode,omitempty"`
+	ErrorCode    string           `json:"error_code,omitempty"`
+	Reason       string           `json:"reason,omitempty"`
+}
+
+// EventBus hands the events of a service to its subscribers. Publishing never
+// blocks: a subscriber that falls a whole buffer behind is dropped and its
+// channel closed, so that it can tell that it missed events.
+type EventBus struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[*Subscription]bool
+}
+
+// Subscription receives the events of one expression, of all expressions, or of
+// the expressions it watches. Watched is guarded by the lock of the bus.
+type Subscription struct {
+	bus          *EventBus
+	expressionID string
+	watched      map[string]bool
+	events       chan Event
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe returns a subscription to the events of an expression, or of all
+// expressions if expressionID is empty, buffering up to buffer events
+func (b *EventBus) Subscribe(expressionID string, buffer int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		bus:          b,
+		expressionID: expressionID,
+		events:       make(chan Event, buffer),
+	}
+	b.subscribers[sub] = true
+	return sub
+}
+
+// SubscribeWatched returns a subscription to the events of the expressions added
+// with Watch, buffering up to buffer events. It starts without any expression.
+func (b *EventBus) SubscribeWatched(buffer int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		bus:     b,
+		watched: make(map[string]bool),
+		events:  make(chan Event, buffer),
+	}
+	b.subscribers[sub] = true
+	return sub
+}
+
+// Publish numbers an event and sends it to the interested subscribers
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Seq = b.seq
+	for sub := range b.subscribers {
+		if !sub.wants(event.ExpressionID) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber is too slow, drop it rather than block the service
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// wants reports whether the subscription receives the events of an expression,
+// the caller must hold the lock of the bus
+func (sub *Subscription) wants(expressionID string) bool {
+	if sub.watched != nil {
+		return sub.watched[expressionID]
+	}
+	return sub.expressionID == "" || sub.expressionID == expressionID
+}
+
+// Watch adds an expression to a subscription created with SubscribeWatched
+func (sub *Subscription) Watch(expressionID string) {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	sub.watched[expressionID] = true
+}
+
+// Unwatch removes an expression from a subscription created with SubscribeWatched
+func (sub *Subscription) Unwatch(expressionID string) {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	delete(sub.watched, expressionID)
+}
+
+// Events returns the channel of the subscription, it is closed when the
+// subscription is closed or the subscriber fell behind
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Close ends the subscription
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	if sub.bus.subscribers[sub] {
+		delete(sub.bus.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Events returns the bus the service publishes its events on
+func (s *Service) Events() *EventBus {
+	return s.events
+}
+
+// emit queues an event to be published when the lock is released, after the
+// change is saved, the caller must hold the lock
+func (s *Service) emit(event Event) {
+	event.Time = s.now()
+	s.pendingEvents = append(s.pendingEvents, event)
+}
+
+// publish sends saved events to the bus, the caller must hold flushMu so that
+// events are published in the order they happened
+func (s *Service) publish(events []Event) {
+	for _, event := range events {
+		s.events.Publish(event)
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collect returns the events buffered in a subscription
+func collect(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestServiceEvents(t *testing.T) {
+	svc := NewService(OperationTimes{})
+	all := svc.Events().Subscribe("", 16)
+	defer all.Close()
+
+	exprID, _ := svc.SubmitExpression("1/0+2")
+	one := svc.Events().Subscribe(exprID, 16)
+	defer one.Close()
+	otherID, _ := svc.SubmitExpression("3")
+
+	task, _ := svc.GetTask()
+	svc.SetTaskError(task.ID, task.LeaseID, FailureDivisionByZero, "division by zero")
+
+	events := collect(one)
+	assert.Len(t, events, 3)
+	assert.Equal(t, EventTaskDispatched, events[0].Type)
+	assert.Equal(t, task.ID, events[0].TaskID)
+	assert.Equal(t, EventTaskFailed, events[1].Type)
+	assert.Equal(t, FailureDivisionByZero, events[1].ErrorCode)
+	assert.Equal(t, EventExpressionStatus, events[2].Type)
+	assert.Equal(t, Failed, events[2].Status)
+
+	// The global subscription sees every expression in order
+	events = collect(all)
+	assert.Len(t, events, 5)
+	assert.Equal(t, exprID, events[0].ExpressionID)
+	assert.Equal(t, InProcess, events[0].Status)
+	assert.Equal(t, otherID, events[1].ExpressionID)
+	assert.Equal(t, Completed, events[1].Status)
+	for i := 1; i < len(events); i++ {
+		assert.Equal(t, events[i-1].Seq+1, events[i].Seq)
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	slow := bus.Subscribe("", 1)
+	fast := bus.Subscribe("", 4)
+	defer fast.Close()
+
+	bus.Publish(Event{ExpressionID: "a"})
+	bus.Publish(Event{ExpressionID: "b"})
+
+	// The slow subscriber is dropped with the events it got so far
+	assert.Len(t, collect(slow), 1)
+	_, ok := <-slow.Events()
+	assert.False(t, ok)
+	slow.Close()
+
+	assert.Len(t, collect(fast), 2)
+}
+
+func TestEventBusWatched(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.SubscribeWatched(16)
+	defer sub.Close()
+
+	// Nothing is received before an expression is watched
+	bus.Publish(Event{ExpressionID: "a"})
+	assert.Empty(t, collect(sub))
+
+	sub.Watch("a")
+	sub.Watch("b")
+	bus.Publish(Event{ExpressionID: "a"})
+	bus.Publish(Event{ExpressionID: "c"})
+	bus.Publish(Event{ExpressionID: "b"})
+	events := collect(sub)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "a", events[0].ExpressionID)
+		assert.Equal(t, "b", events[1].ExpressionID)
+	}
+
+	sub.Unwatch("a")
+	bus.Publish(Event{ExpressionID: "a"})
+	assert.Empty(t, collect(sub))
+}
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultSnapshotEvery is the number of log records after which the file store writes a snapshot
+const DefaultSnapshotEvery = 1000
+
+// Names of the files the file store keeps in its directory. A full log is renamed
+// to the segment, which is merged into the snapshot in the background.
+const (
+	walFile      = "wal.log"
+	segmentFile  = "wal.segment.log"
+	snapshotFile = "snapshot.json"
+)
+
+// storedTask is a task as written to disk, with the dependencies the API does not show
+type storedTask struct {
+	Task
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// walRecord is one line of the write-ahead log, holding an expression or a task,
+// or the ID of a removed expression or task
+type walRecord struct {
+	Expression        *ExpressionData `json:"expression,omitempty"`
+	Task              *storedTask     `json:"task,omitempty"`
+	DeletedExpression string          `json:"deleted_expression,omitempty"`
+	DeletedTask       string          `json:"deleted_task,omitempty"`
+}
+
+// snapshot is the full state of the store at the time a log segment was merged into it
+type snapshot struct {
+	Expressions []ExpressionData `json:"expressions"`
+	Tasks       []storedTask     `json:"tasks"`
+}
+
+// storeState is the state of a file store read back from its files
+type storeState struct {
+	expressions map[string]ExpressionData
+	tasks       map[string]Task
+}
+
+func newStoreState() *storeState {
+	return &storeState{
+		expressions: make(map[string]ExpressionData),
+		tasks:       make(map[string]Task),
+	}
+}
+
+// apply updates the state with a record
+func (st *storeState) apply(record walRecord) {
+	if record.Expression != nil {
+		st.expressions[record.Expression.ID] = *record.Expression
+	}
+	if record.Task != nil {
+		task := record.Task.Task
+		task.Dependencies = record.Task.Dependencies
+		st.tasks[task.ID] = copyTask(&task)
+	}
+	if record.DeletedExpression != "" {
+		delete(st.expressions, record.DeletedExpression)
+	}
+	if record.DeletedTask != "" {
+		delete(st.tasks, record.DeletedTask)
+	}
+}
+
+// FileStore keeps the state in a directory: every save is appended to a
+// write-ahead log and synced before Save returns. Once the log holds
+// snapshotEvery records it becomes a segment that a background goroutine
+// merges into the snapshot, while saves go on to a new log, so the caller
+// never waits for a snapshot. A record cut short by a crash is dropped
+// when the log is read back.
+type FileStore struct {
+	mu            sync.Mutex
+	dir           string
+	wal           *os.File
+	records       int
+	snapshotEvery int
+	// segment is set while a segment waits to be merged, merging while it is merged
+	segment   bool
+	merging   bool
+	mergeDone sync.WaitGroup
+	// files keeps Load from reading the snapshot and segment while they are replaced
+	files sync.RWMutex
+}
+
+// OpenFileStore opens the store in dir, creating the directory if needed.
+// A segment left behind by a crash is merged into the snapshot first.
+func OpenFileStore(dir string, snapshotEvery int) (*FileStore, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+	}
+	if _, err := os.Stat(f.path(segmentFile)); err == nil {
+		if err := f.merge(); err != nil {
+			return nil, fmt.Errorf("merging %s: %w", segmentFile, err)
+		}
+	}
+
+	records, err := readLog(f.path(walFile), newStoreState())
+	if err != nil {
+		return nil, err
+	}
+	f.records = records
+
+	if err := f.openLog(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// path returns the name of a file of the store
+func (f *FileStore) path(name string) string {
+	return filepath.Join(f.dir, name)
+}
+
+// openLog opens the log for appending
+func (f *FileStore) openLog() error {
+	wal, err := os.OpenFile(f.path(walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	f.wal = wal
+	return nil
+}
+
+// readSnapshot applies the last snapshot to the state, a missing snapshot means an empty store
+func (f *FileStore) readSnapshot(st *storeState) error {
+	data, err := os.ReadFile(f.path(snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	for _, expr := range snap.Expressions {
+		st.expressions[expr.ID] = expr
+	}
+	for _, task := range snap.Tasks {
+		st.apply(walRecord{Task: &task})
+	}
+	return nil
+}
+
+// readLog applies the records of a log to the state and returns their number.
+// A last record that a crash left incomplete is cut off, so that new records
+// follow a full line. A missing log holds no records.
+func readLog(name string, st *storeState) (int, error) {
+	file, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records := 0
+	var valid int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return records, nil
+			}
+			log.Printf("Dropping incomplete record at the end of %s", name)
+			return records, os.Truncate(name, valid)
+		}
+		if err != nil {
+			return records, err
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, fmt.Errorf("reading %s record %d: %w", filepath.Base(name), records+1, err)
+		}
+		st.apply(record)
+		records++
+		valid += int64(len(line))
+	}
+}
+
+// Save appends the expressions and tasks to the log and syncs it to disk
+func (f *FileStore) Save(expressions []ExpressionData, tasks []Task) error {
+	var records []walRecord
+	for i := range expressions {
+		records = append(records, walRecord{Expression: &expressions[i]})
+	}
+	for i := range tasks {
+		records = append(records, walRecord{Task: &storedTask{Task: tasks[i], Dependencies: tasks[i].Dependencies}})
+	}
+	return f.write(records)
+}
+
+// Delete appends the removal of the expressions and tasks to the log and syncs it to disk
+func (f *FileStore) Delete(expressionIDs, taskIDs []string) error {
+	var records []walRecord
+	for _, id := range expressionIDs {
+		records = append(records, walRecord{DeletedExpression: id})
+	}
+	for _, id := range taskIDs {
+		records = append(records, walRecord{DeletedTask: id})
+	}
+	return f.write(records)
+}
+
+// write appends records to the log in a single write and syncs it. Once the
+// log is full it becomes the segment and a merge is started in the background.
+func (f *FileStore) write(records []walRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.wal.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+
+	f.records += len(records)
+	if f.records < f.snapshotEvery || f.merging {
+		return nil
+	}
+
+	// A segment whose merge failed is merged again before the log is rotated
+	if !f.segment {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	f.merging = true
+	f.mergeDone.Add(1)
+	go f.mergeInBackground()
+	return nil
+}
+
+// rotate renames the log to the segment and starts a new log, the caller must hold mu
+func (f *FileStore) rotate() error {
+	if err := f.wal.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path(walFile), f.path(segmentFile)); err != nil {
+		// Keep appending to the old log
+		if openErr := f.openLog(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	f.segment = true
+	f.records = 0
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+	return f.openLog()
+}
+
+// mergeInBackground merges the segment and logs a failure, the merge is tried
+// again when the log is full the next time
+func (f *FileStore) mergeInBackground() {
+	defer f.mergeDone.Done()
+
+	err := f.merge()
+	if err != nil {
+		log.Printf("Error merging %s into %s: %v", segmentFile, snapshotFile, err)
+	}
+
+	f.mu.Lock()
+	f.merging = false
+	if err == nil {
+		f.segment = false
+	}
+	f.mu.Unlock()
+}
+
+// merge writes a new snapshot from the old one and the segment, renames it into
+// place and removes the segment. The directory is synced after each step, so a
+// crash leaves either the old snapshot with the segment or the new snapshot,
+// maybe still with the segment, which holds nothing the snapshot lacks.
+func (f *FileStore) merge() error {
+	st := newStoreState()
+	if err := f.readSnapshot(st); err != nil {
+		return err
+	}
+	if _, err := readLog(f.path(segmentFile), st); err != nil {
+		return err
+	}
+
+	snap := snapshot{
+		Expressions: make([]ExpressionData, 0, len(st.expressions)),
+		Tasks:       make([]storedTask, 0, len(st.tasks)),
+	}
+	for _, expr := range st.expressions {
+		snap.Expressions = append(snap.Expressions, expr)
+	}
+	for _, task := range st.tasks {
+		snap.Tasks = append(snap.Tasks, storedTask{Task: task, Dependencies: task.Dependencies})
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path(snapshotFile + ".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	f.files.Lock()
+	defer f.files.Unlock()
+
+	if err := os.Rename(tmp, f.path(snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+	if err := os.Remove(f.path(segmentFile)); err != nil {
+		return err
+	}
+	return syncDir(f.dir)
+}
+
+// writeFileSync writes a file and syncs it to disk
+func writeFileSync(name string, data []byte) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs a directory, so that files created, renamed or removed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Load reads the state back from the snapshot, the segment and the log
+func (f *FileStore) Load() ([]ExpressionData, []Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files.RLock()
+	defer f.files.RUnlock()
+
+	st := newStoreState()
+	if err := f.readSnapshot(st); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range []string{segmentFile, walFile} {
+		if _, err := readLog(f.path(name), st); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	expressions := make([]ExpressionData, 0, len(st.expressions))
+	for _, expr := range st.expressions {
+		expressions = append(expressions, expr)
+	}
+	tasks := make([]Task, 0, len(st.tasks))
+	for _, task := range st.tasks {
+		tasks = append(tasks, task)
+	}
+	return expressions, tasks, nil
+}
+
+// Close waits for a running merge and closes the log
+func (f *FileStore) Close() error {
+	f.mergeDone.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.wal.Close()
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+
+	svc := NewService(OperationTimes{}, WithStore(store))
+	exprID, _ := svc.SubmitExpression("1+2*3")
+	task, _ := svc.GetTask()
+	assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, "6"))
+	assert.NoError(t, store.Close())
+
+	// A crash in the middle of a write leaves an incomplete last record
+	wal, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	wal.WriteString(`{"task":{"id":"task_`)
+	wal.Close()
+
+	store, err = OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+	restarted := NewService(OperationTimes{}, WithStore(store))
+	assert.NoError(t, restarted.Recover())
+
+	root, found := restarted.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Value("1"), root.Args[0].Value)
+	assert.Equal(t, Value("6"), root.Args[1].Value)
+	assert.NoError(t, restarted.SetTaskResult(root.ID, root.LeaseID, "7"))
+	assert.NoError(t, store.Close())
+
+	// Records after the dropped one are read back
+	store, err = OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+	defer store.Close()
+	expressions, tasks, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, expressions, 1)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, exprID, expressions[0].ID)
+	assert.Equal(t, Completed, expressions[0].Status)
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, 3)
+	assert.NoError(t, err)
+
+	svc := NewService(OperationTimes{}, WithStore(store))
+	svc.SubmitExpression("1+2")
+	svc.SubmitExpression("3+4")
+	svc.SubmitExpression("5+6")
+	assert.NoError(t, store.Close())
+
+	// The full log was merged into the snapshot, a new log holds only the last expression
+	_, err = os.Stat(filepath.Join(dir, snapshotFile))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, segmentFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, 2, store.records)
+
+	store, err = OpenFileStore(dir, 3)
+	assert.NoError(t, err)
+	defer store.Close()
+	expressions, tasks, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, expressions, 3)
+	assert.Len(t, tasks, 3)
+	for _, task := range tasks {
+		assert.Empty(t, task.Dependencies)
+	}
+}
+
+func TestFileStoreInterruptedMerge(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+
+	svc := NewService(OperationTimes{}, WithStore(store))
+	svc.SubmitExpression("1+2")
+	svc.SubmitExpression("3+4")
+	assert.NoError(t, store.Close())
+
+	// A crash right after the log became a segment leaves it unmerged
+	assert.NoError(t, os.Rename(filepath.Join(dir, walFile), filepath.Join(dir, segmentFile)))
+
+	store, err = OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, segmentFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	restarted := NewService(OperationTimes{}, WithStore(store))
+	assert.NoError(t, restarted.Recover())
+	restarted.SubmitExpression("5+6")
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	assert.NoError(t, err)
+	defer store.Close()
+	expressions, tasks, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, expressions, 3)
+	assert.Len(t, tasks, 3)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// Function describes a built-in function that agents can evaluate
+type Function struct {
+	MinArgs int
+	MaxArgs int // -1 means any number of arguments
+	Apply   func(args []float64) (float64, error)
+	// Exact evaluates the function in exact numeric modes, it is nil for
+	// functions such as sin whose results can not be represented exactly.
+	// Precision is the number of decimal places for approximated results,
+	// zero means that only exact results are allowed.
+	Exact func(args []*big.Rat, precision int) (*big.Rat, error)
+}
+
+// functions is the registry of built-in functions by name
+var functions = map[string]Function{
+	"sqrt": {MinArgs: 1, MaxArgs: 1, Apply: func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, fmt.Errorf("square root of negative number")
+		}
+		return math.Sqrt(args[0]), nil
+	}, Exact: ratSqrt},
+	"abs": {MinArgs: 1, MaxArgs: 1, Apply: func(args []float64) (float64, error) {
+		return math.Abs(args[0]), nil
+	}, Exact: func(args []*big.Rat, _ int) (*big.Rat, error) {
+		return new(big.Rat).Abs(args[0]), nil
+	}},
+	"min": {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}, Exact: func(args []*big.Rat, _ int) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}},
+	"max": {MinArgs: 1, MaxArgs: -1, Apply: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}, Exact: func(args []*big.Rat, _ int) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}},
+	// log(x) is the natural logarithm, log(x, b) is the logarithm to base b
+	"log": {MinArgs: 1, MaxArgs: 2, Apply: func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, fmt.Errorf("logarithm of non-positive number")
+		}
+		if len(args) == 1 {
+			return math.Log(args[0]), nil
+		}
+		if args[1] <= 0 || args[1] == 1 {
+			return 0, fmt.Errorf("invalid logarithm base")
+		}
+		return math.Log(args[0]) / math.Log(args[1]), nil
+	}},
+	"sin": {MinArgs: 1, MaxArgs: 1, Apply: func(args []float64) (float64, error) {
+		return math.Sin(args[0]), nil
+	}},
+	"cos": {MinArgs: 1, MaxArgs: 1, Apply: func(args []float64) (float64, error) {
+		return math.Cos(args[0]), nil
+	}},
+	// round(x) rounds to an integer, round(x, n) keeps n decimal places
+	"round": {MinArgs: 1, MaxArgs: 2, Apply: func(args []float64) (float64, error) {
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		if args[1] != math.Trunc(args[1]) {
+			return 0, fmt.Errorf("round precision must be an integer")
+		}
+		scale := math.Pow(10, args[1])
+		return math.Round(args[0]*scale) / scale, nil
+	}, Exact: ratRound},
+}
+
+// ratSqrt returns exact roots of perfect squares and rounds all other roots to precision decimal places
+// if approximations are allowed
+func ratSqrt(args []*big.Rat, precision int) (*big.Rat, error) {
+	x := args[0]
+	if x.Sign() < 0 {
+		return nil, fmt.Errorf("square root of negative number")
+	}
+
+	num, denom := new(big.Int).Sqrt(x.Num()), new(big.Int).Sqrt(x.Denom())
+	if new(big.Int).Mul(num, num).Cmp(x.Num()) == 0 && new(big.Int).Mul(denom, denom).Cmp(x.Denom()) == 0 {
+		return new(big.Rat).SetFrac(num, denom), nil
+	}
+	if precision <= 0 {
+		return nil, fmt.Errorf("square root of %s is not rational", x.RatString())
+	}
+
+	// Compute with a few more bits than the requested decimal places need
+	prec := uint(float64(precision)*math.Log2(10)) + 64
+	root := new(big.Float).SetPrec(prec).SetRat(x)
+	root.Sqrt(root)
+	return parseRat(root.Text('f', precision))
+}
+
+// ratRound rounds half away from zero to an integer, or to the given number of decimal places
+func ratRound(args []*big.Rat, _ int) (*big.Rat, error) {
+	scale := big.NewRat(1, 1)
+	if len(args) == 2 {
+		if !args[1].IsInt() {
+			return nil, fmt.Errorf("round precision must be an integer")
+		}
+		var err error
+		scale, err = ratPow(big.NewRat(10, 1), args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	scaled := new(big.Rat).Mul(args[0], scale)
+	// Add one half towards the sign and truncate
+	half := big.NewRat(int64(scaled.Sign()), 2)
+	scaled.Add(scaled, half)
+	truncated := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	return new(big.Rat).Quo(new(big.Rat).SetInt(truncated), scale), nil
+}
+
+// IsFunction checks if name is a registered built-in function
+func IsFunction(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
+
+// FunctionNames returns the names of all built-in functions in sorted order
+func FunctionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkArity checks that a function is called with a supported number of arguments
+func checkArity(name string, argc int) error {
+	fn, ok := functions[name]
+	if !ok {
+		return fmt.Errorf("unknown function: %s", name)
+	}
+	if argc < fn.MinArgs || (fn.MaxArgs >= 0 && argc > fn.MaxArgs) {
+		return fmt.Errorf("wrong number of arguments for function %s: %d", name, argc)
+	}
+	return nil
+}
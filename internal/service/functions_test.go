@@ -0,0 +1,91 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceFunctionErrors(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	for _, expression := range []string{
+		"foo(1)",    // unknown function
+		"sqrt",      // no call parentheses
+		"sqrt(1,2)", // too many arguments
+		"max()",     // too few arguments
+		"max(1,)",   // missing argument
+		"(1,2)",     // comma outside of a call
+		"1,2",       // comma at top level
+		"()",        // empty parentheses
+	} {
+		_, err := svc.SubmitExpression(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestServiceFunctionTasks(t *testing.T) {
+	svc := NewService(OperationTimes{Functions: map[string]int{"max": 7}})
+
+	id, err := svc.SubmitExpression("max(1,2+3,4)")
+	assert.NoError(t, err)
+
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Addition, task.Operation)
+	assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, "5"))
+
+	task, found = svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Operation("max"), task.Operation)
+	assert.Equal(t, 7, task.OperationTime)
+	assert.Len(t, task.Args, 3)
+	assert.Equal(t, Operand{Value: "1"}, task.Args[0])
+	assert.Equal(t, Operand{Value: "4"}, task.Args[2])
+	assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, "5"))
+
+	expr, _ := svc.GetExpression(id)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, 5.0, *expr.Result)
+}
+
+func TestProcessTaskFunction(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		expected float64
+	}{
+		{"sqrt", []string{"9"}, 3},
+		{"abs", []string{"-2.5"}, 2.5},
+		{"min", []string{"3", "-1", "2"}, -1},
+		{"max", []string{"3", "-1", "2"}, 3},
+		{"log", []string{"1"}, 0},
+		{"log", []string{"8", "2"}, 3},
+		{"sin", []string{"0"}, 0},
+		{"cos", []string{"0"}, 1},
+		{"round", []string{"2.5"}, 3},
+		{"round", []string{"3.14159", "2"}, 3.14},
+	}
+
+	for _, c := range cases {
+		result, err := ProcessTask(&Task{Operation: Operation(c.name), Args: literals(c.args...)})
+		assert.NoError(t, err, c.name)
+		value, _ := strconv.ParseFloat(string(result), 64)
+		assert.InDelta(t, c.expected, value, 1e-9, c.name)
+	}
+
+	// Domain errors
+	_, err := ProcessTask(&Task{Operation: "sqrt", Args: literals("-1")})
+	assert.Error(t, err)
+	_, err = ProcessTask(&Task{Operation: "log", Args: literals("0")})
+	assert.Error(t, err)
+	_, err = ProcessTask(&Task{Operation: "log", Args: literals("8", "1")})
+	assert.Error(t, err)
+
+	// Arity and unknown function errors
+	_, err = ProcessTask(&Task{Operation: "sqrt", Args: literals("1", "2")})
+	assert.Error(t, err)
+	_, err = ProcessTask(&Task{Operation: "unknown", Args: literals("1")})
+	assert.Error(t, err)
+}
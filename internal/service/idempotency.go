@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long an idempotency key is remembered by default
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// ErrIdempotencyConflict is returned when an idempotency key is reused for a different request
+var ErrIdempotencyConflict = errors.New("idempotency key reused with a different request")
+
+// idempotencyRecord is the expression an idempotency key was used for, with a
+// fingerprint of the request that submitted it
+type idempotencyRecord struct {
+	expressionID string
+	fingerprint  string
+	expiresAt    time.Time
+}
+
+// WithIdempotencyTTL sets how long an idempotency key is remembered after its first use
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		s.idempotencyTTL = ttl
+	}
+}
+
+// SubmitExpressionOnce submits an expression under an idempotency key. A repeated
+// submission with the same key and request fingerprint returns the ID of the first
+// expression and true instead of adding another one, a different fingerprint gets
+// ErrIdempotencyConflict. The key is stored with its expression until the TTL runs
+// out or the expression is collected, and only for expressions that were accepted,
+// so a rejected request may be retried as is.
+func (s *Service) SubmitExpressionOnce(key, fingerprint, expression string, opts ExpressionOptions) (string, bool, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.now()
+	if id, found, err := s.lookupKey(key, fingerprint, now); found || err != nil {
+		return id, found, err
+	}
+
+	root, err := parseTree(expression, s.expressionMode(opts))
+	id := s.addExpression(expression, opts, root, err)
+	if err != nil {
+		return "", false, err
+	}
+
+	expr := s.expressions[id]
+	expr.IdempotencyKey = key
+	expr.Fingerprint = fingerprint
+	s.restoreIdempotencyKey(expr)
+	return id, false, nil
+}
+
+// LookupIdempotencyKey returns the ID of the expression submitted under an idempotency
+// key that has not expired, a different fingerprint gets ErrIdempotencyConflict.
+// It lets a retry get its expression back without checking the request again.
+func (s *Service) LookupIdempotencyKey(key, fingerprint string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookupKey(key, fingerprint, s.now())
+}
+
+// lookupKey finds the expression of an idempotency key, the caller must hold the lock
+func (s *Service) lookupKey(key, fingerprint string, now time.Time) (string, bool, error) {
+	record, ok := s.idempotencyKeys[key]
+	if !ok || !now.Before(record.expiresAt) {
+		return "", false, nil
+	}
+	if record.fingerprint != fingerprint {
+		return "", false, ErrIdempotencyConflict
+	}
+	return record.expressionID, true, nil
+}
+
+// restoreIdempotencyKey indexes the idempotency key of an expression, unless it
+// expired or a later expression took it, the caller must hold the lock
+func (s *Service) restoreIdempotencyKey(expr *ExpressionData) {
+	if expr.IdempotencyKey == "" {
+		return
+	}
+	expiresAt := expr.SubmittedAt.Add(s.idempotencyTTL)
+	if record, ok := s.idempotencyKeys[expr.IdempotencyKey]; !s.now().Before(expiresAt) || (ok && !record.expiresAt.Before(expiresAt)) {
+		return
+	}
+	s.idempotencyKeys[expr.IdempotencyKey] = idempotencyRecord{
+		expressionID: expr.ID,
+		fingerprint:  expr.Fingerprint,
+		expiresAt:    expiresAt,
+	}
+}
+
+// forgetIdempotencyKey drops the idempotency key of a collected expression,
+// the caller must hold the lock
+func (s *Service) forgetIdempotencyKey(expr *ExpressionData) {
+	if record, ok := s.idempotencyKeys[expr.IdempotencyKey]; ok && record.expressionID == expr.ID {
+		delete(s.idempotencyKeys, expr.IdempotencyKey)
+	}
+}
+
+// expireIdempotencyKeys forgets the keys whose TTL ran out, the caller must hold the lock
+func (s *Service) expireIdempotencyKeys(now time.Time) {
+	for key, record := range s.idempotencyKeys {
+		if !now.Before(record.expiresAt) {
+			delete(s.idempotencyKeys, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceSubmitExpressionOnce(t *testing.T) {
+	now := time.Now()
+	svc := NewService(OperationTimes{}, WithIdempotencyTTL(time.Hour))
+	svc.now = func() time.Time { return now }
+
+	id, replayed, err := svc.SubmitExpressionOnce("key-1", "a", "1+2", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+
+	// A retry gets the first expression back without adding another one
+	again, replayed, err := svc.SubmitExpressionOnce("key-1", "a", "1+2", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, id, again)
+	assert.Len(t, svc.GetExpressions(), 1)
+
+	// The key can be looked up without submitting anything
+	found, replayed, err := svc.LookupIdempotencyKey("key-1", "a")
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, id, found)
+	_, replayed, _ = svc.LookupIdempotencyKey("unknown", "a")
+	assert.False(t, replayed)
+
+	// The same key for a different request is a conflict
+	_, _, err = svc.SubmitExpressionOnce("key-1", "b", "2+2", ExpressionOptions{})
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+	_, _, err = svc.LookupIdempotencyKey("key-1", "b")
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	// Rejected expressions do not take the key
+	_, _, err = svc.SubmitExpressionOnce("key-2", "c", "2+", ExpressionOptions{})
+	assert.Error(t, err)
+	other, replayed, err := svc.SubmitExpressionOnce("key-2", "d", "2+3", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, id, other)
+	assert.Equal(t, 2, svc.GetMetrics().IdempotencyKeys)
+
+	// Once the TTL runs out the key can be used again, and the collector forgets it
+	now = now.Add(time.Hour)
+	third, replayed, err := svc.SubmitExpressionOnce("key-1", "b", "2+2", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, id, third)
+
+	now = now.Add(time.Hour)
+	svc.CollectGarbage()
+	assert.Equal(t, 0, svc.GetMetrics().IdempotencyKeys)
+}
+
+func TestServiceIdempotencyKeyRecover(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	svc := NewService(OperationTimes{}, WithStore(store), WithIdempotencyTTL(time.Hour))
+	svc.now = func() time.Time { return now }
+
+	id, _, err := svc.SubmitExpressionOnce("key-1", "a", "1+2", ExpressionOptions{})
+	assert.NoError(t, err)
+	_, _, err = svc.SubmitExpressionOnce("key-2", "b", "2+3", ExpressionOptions{})
+	assert.NoError(t, err)
+
+	// The key is stored with its expression and survives a restart
+	now = now.Add(30 * time.Minute)
+	restarted := NewService(OperationTimes{}, WithStore(store), WithIdempotencyTTL(time.Hour))
+	restarted.now = func() time.Time { return now }
+	assert.NoError(t, restarted.Recover())
+
+	again, replayed, err := restarted.SubmitExpressionOnce("key-1", "a", "1+2", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, id, again)
+	_, _, err = restarted.SubmitExpressionOnce("key-1", "b", "2+2", ExpressionOptions{})
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+	assert.Len(t, restarted.GetExpressions(), 2)
+
+	// Keys that expired before the restart are not restored
+	now = now.Add(time.Hour)
+	expired := NewService(OperationTimes{}, WithStore(store), WithIdempotencyTTL(time.Hour))
+	expired.now = func() time.Time { return now }
+	assert.NoError(t, expired.Recover())
+	assert.Equal(t, 0, expired.GetMetrics().IdempotencyKeys)
+}
+
+func TestServiceIdempotencyKeyCollected(t *testing.T) {
+	now := time.Now()
+	svc := NewService(OperationTimes{}, WithIdempotencyTTL(time.Hour), WithRetention(RetentionPolicy{MaxAge: time.Minute}))
+	svc.now = func() time.Time { return now }
+
+	id, _, err := svc.SubmitExpressionOnce("key-1", "a", "7", ExpressionOptions{})
+	assert.NoError(t, err)
+
+	// The expression is collected long before the key expires, and the key goes with it
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, svc.CollectGarbage())
+	assert.Equal(t, 0, svc.GetMetrics().IdempotencyKeys)
+
+	other, replayed, err := svc.SubmitExpressionOnce("key-1", "a", "7", ExpressionOptions{})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.NotEqual(t, id, other)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultLeaseGrace is the time an agent gets on top of twice the operation time of a task
+const DefaultLeaseGrace = 5 * time.Second
+
+// grantLease hands a task out under a new lease. The deadline allows for twice the
+// simulated operation time plus a grace period for network round trips.
+func (s *Service) grantLease(task *Task, agentID string) {
+	task.AgentID = agentID
+	task.LeaseID = uuid.New().String()
+	dispatchedAt := s.now()
+	task.LeaseDeadline = dispatchedAt.Add(2*time.Duration(task.OperationTime)*time.Millisecond + s.leaseGrace)
+	task.DispatchedAt = &dispatchedAt
+	task.Attempts++
+}
+
+// releaseLease ends the lease of a task, results reported under it are rejected afterwards
+func (s *Service) releaseLease(task *Task) {
+	task.AgentID = ""
+	task.LeaseID = ""
+	task.LeaseDeadline = time.Time{}
+}
+
+// requeueTask takes a task back from the agent holding it and queues it again,
+// the caller must hold the lock
+func (s *Service) requeueTask(task *Task, reason string) {
+	s.emit(Event{Type: EventTaskRequeued, ExpressionID: task.ExpressionID, TaskID: task.ID, Operation: task.Operation, AgentID: task.AgentID, Reason: reason})
+	task.Status = "pending"
+	s.releaseLease(task)
+	s.changes.task(task.ID)
+	s.markReady(task.ID)
+}
+
+// RequeueExpiredTasks returns tasks whose lease has expired to the ready set,
+// so that another agent can pick them up. It returns the number of requeued tasks.
+func (s *Service) RequeueExpiredTasks() int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.now()
+	requeued := 0
+	for taskID, task := range s.tasks {
+		if task.LeaseID == "" || s.completedTasks[taskID] || now.Before(task.LeaseDeadline) {
+			continue
+		}
+
+		log.Printf("Lease %s of task %s expired after attempt %d, requeueing", task.LeaseID, taskID, task.Attempts)
+		s.requeueTask(task, "lease expired")
+		requeued++
+	}
+	return requeued
+}
+
+// RunLeaseReaper requeues expired tasks and tasks of dead agents and fails
+// expressions past their deadline every interval until the context is done
+func (s *Service) RunLeaseReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RequeueExpiredTasks()
+			s.ReapDeadAgents()
+			s.ExpireDeadlines()
+		}
+	}
+}
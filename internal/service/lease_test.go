@@ -0,0 +1,66 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceTaskLease(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewService(OperationTimes{Addition: 100}, WithLeaseGrace(time.Second), WithStore(store))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	events := svc.Events().Subscribe("", 16)
+	defer events.Close()
+
+	exprID, _ := svc.SubmitExpression("2+2")
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.NotEmpty(t, task.LeaseID)
+	assert.Equal(t, now.Add(1200*time.Millisecond), task.LeaseDeadline)
+	assert.Equal(t, 1, task.Attempts)
+
+	// The lease is still valid
+	assert.Equal(t, 0, svc.RequeueExpiredTasks())
+	_, found = svc.GetTask()
+	assert.False(t, found)
+
+	// The agent is lost, the task is handed out again under a new lease
+	now = now.Add(2 * time.Second)
+	collect(events)
+	assert.Equal(t, 1, svc.RequeueExpiredTasks())
+
+	// The requeue is saved and published
+	_, tasks, _ := store.Load()
+	assert.Equal(t, "pending", tasks[0].Status)
+	assert.Empty(t, tasks[0].LeaseID)
+	requeued := collect(events)
+	if assert.Len(t, requeued, 1) {
+		assert.Equal(t, EventTaskRequeued, requeued[0].Type)
+		assert.Equal(t, task.ID, requeued[0].TaskID)
+		assert.Equal(t, "lease expired", requeued[0].Reason)
+	}
+	retry, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, task.ID, retry.ID)
+	assert.NotEqual(t, task.LeaseID, retry.LeaseID)
+	assert.Equal(t, 2, retry.Attempts)
+
+	// A late result from the first agent is rejected
+	err := svc.SetTaskResult(task.ID, task.LeaseID, "4")
+	assert.ErrorIs(t, err, ErrStaleLease)
+
+	assert.NoError(t, svc.SetTaskResult(retry.ID, retry.LeaseID, "4"))
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, Completed, expr.Status)
+
+	// Duplicate results are rejected as well
+	err = svc.SetTaskResult(retry.ID, retry.LeaseID, "4")
+	assert.ErrorIs(t, err, ErrStaleLease)
+
+	// Completed tasks are never requeued
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0, svc.RequeueExpiredTasks())
+}
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NumericMode selects how task values are represented and computed
+type NumericMode string
+
+const (
+	// FloatMode computes with float64
+	FloatMode NumericMode = "float"
+	// DecimalMode computes exactly with math/big. Values are decimal strings,
+	// results that do not terminate (such as 1/3) are rounded to the precision.
+	DecimalMode NumericMode = "decimal"
+	// RationalMode computes exactly with big.Rat. Values are fractions such as "1/3",
+	// integers are written without a denominator.
+	RationalMode NumericMode = "rational"
+)
+
+// DefaultDecimalPrecision is the number of decimal places kept for results that do not terminate
+const DefaultDecimalPrecision = 50
+
+// Failure codes reported by agents for tasks that can not be computed
+const (
+	FailureDivisionByZero = "division_by_zero"
+	FailureOutOfRange     = "out_of_range"
+	FailureComputation    = "computation_error"
+)
+
+var (
+	// ErrDivisionByZero is returned for divisions by zero, including zero raised to a negative power
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrOutOfRange is returned for results that can not be represented
+	ErrOutOfRange = errors.New("result out of range")
+)
+
+// FailureCode classifies an error returned by ProcessTask for reporting to the orchestrator
+func FailureCode(err error) string {
+	switch {
+	case errors.Is(err, ErrDivisionByZero):
+		return FailureDivisionByZero
+	case errors.Is(err, ErrOutOfRange):
+		return FailureOutOfRange
+	default:
+		return FailureComputation
+	}
+}
+
+// maxExactExponent limits integer powers in exact modes to keep results a sane size
+const maxExactExponent = 10000
+
+// maxExactBits limits the bit length of the numerator and denominator of exact
+// results, about 30000 decimal digits, since repeated operations grow them without bound
+const maxExactBits = 100000
+
+// Value is a number exchanged between the orchestrator and agents. It is written as
+// a JSON number when possible and as a JSON string otherwise, e.g. for fractions
+// like "1/3", so that no value has to go through float64. Both forms are accepted.
+// Tasks, graph nodes and events write the values of exact modes as JSON strings.
+type Value string
+
+// quotedValue is a value that is always written as a JSON string. Exact values are
+// written this way, a client decoding JSON numbers into float64 would round them.
+type quotedValue Value
+
+// quotedOperand is an operand with its value written as a JSON string
+type quotedOperand struct {
+	Ref   string      `json:"ref,omitempty"`
+	Value quotedValue `json:"value,omitempty"`
+}
+
+// MarshalJSON writes the value as a JSON string
+func (v quotedValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(v))
+}
+
+// quoteOperands returns the operands with their values written as JSON strings
+func quoteOperands(args []Operand) []quotedOperand {
+	quoted := make([]quotedOperand, len(args))
+	for i, arg := range args {
+		quoted[i] = quotedOperand{Ref: arg.Ref, Value: quotedValue(arg.Value)}
+	}
+	return quoted
+}
+
+// quoteResult returns a result written as a JSON string, or nil if there is none
+func quoteResult(result *Value) *quotedValue {
+	if result == nil {
+		return nil
+	}
+	quoted := quotedValue(*result)
+	return &quoted
+}
+
+// MarshalJSON writes the value as a JSON number or string
+func (v Value) MarshalJSON() ([]byte, error) {
+	s := string(v)
+	if s != "" && (s[0] == '-' || s[0] >= '0' && s[0] <= '9') && json.Valid([]byte(s)) {
+		return []byte(s), nil
+	}
+	return json.Marshal(s)
+}
+
+// MarshalJSON writes the task with its values as JSON strings in exact modes
+func (t Task) MarshalJSON() ([]byte, error) {
+	type plain Task
+	if !t.Mode.IsExact() {
+		return json.Marshal(plain(t))
+	}
+	return json.Marshal(struct {
+		plain
+		Args   []quotedOperand `json:"args"`
+		Result *quotedValue    `json:"result,omitempty"`
+	}{plain(t), quoteOperands(t.Args), quoteResult(t.Result)})
+}
+
+// MarshalJSON writes the node with its values as JSON strings in exact modes
+func (n TaskNode) MarshalJSON() ([]byte, error) {
+	type plain TaskNode
+	if !n.Mode.IsExact() {
+		return json.Marshal(plain(n))
+	}
+	return json.Marshal(struct {
+		plain
+		Args   []quotedOperand `json:"args"`
+		Result *quotedValue    `json:"result,omitempty"`
+	}{plain(n), quoteOperands(n.Args), quoteResult(n.Result)})
+}
+
+// MarshalJSON writes the result of the event as a JSON string in exact modes
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	if !e.Mode.IsExact() {
+		return json.Marshal(plain(e))
+	}
+	return json.Marshal(struct {
+		plain
+		Result quotedValue `json:"result,omitempty"`
+	}{plain(e), quotedValue(e.Result)})
+}
+
+// UnmarshalJSON reads the value from a JSON number or string
+func (v *Value) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*v = Value(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*v = Value(n)
+	return nil
+}
+
+// ParseNumericMode validates the name of a numeric mode, an empty name means FloatMode
+func ParseNumericMode(name string) (NumericMode, error) {
+	switch NumericMode(name) {
+	case "", FloatMode:
+		return FloatMode, nil
+	case DecimalMode:
+		return DecimalMode, nil
+	case RationalMode:
+		return RationalMode, nil
+	default:
+		return "", fmt.Errorf("unknown numeric mode: %s", name)
+	}
+}
+
+// ProcessTask performs the operation of a task in its numeric mode
+// and returns the result encoded the same way as the arguments
+func ProcessTask(task *Task) (Value, error) {
+	args := make([]string, len(task.Args))
+	for i, arg := range task.Args {
+		if !arg.Resolved() {
+			return "", fmt.Errorf("unresolved arg%d: %s", i+1, arg.Ref)
+		}
+		args[i] = string(arg.Value)
+	}
+
+	// Simulate long computation
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+
+	if task.Mode.IsExact() {
+		result, err := processExact(task.Operation, args, task.Precision)
+		if err != nil {
+			return "", err
+		}
+		if result.Num().BitLen() > maxExactBits || result.Denom().BitLen() > maxExactBits {
+			return "", ErrOutOfRange
+		}
+		return Value(formatExact(result, task.Mode, task.Precision)), nil
+	}
+
+	result, err := processFloat(task.Operation, args)
+	if err != nil {
+		return "", err
+	}
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return "", ErrOutOfRange
+	}
+	// The shortest representation that parses back to the same float64
+	return Value(strconv.FormatFloat(result, 'g', -1, 64)), nil
+}
+
+// IsExact reports whether the mode computes with math/big instead of float64
+func (mode NumericMode) IsExact() bool {
+	return mode == DecimalMode || mode == RationalMode
+}
+
+// ValidateValue checks that a result reported for a task is a number in the given mode
+func ValidateValue(mode NumericMode, value string) error {
+	if mode.IsExact() {
+		_, err := parseRat(value)
+		return err
+	}
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+// approximate converts an encoded value of any mode to the nearest float64
+func approximate(value string) float64 {
+	if r, err := parseRat(value); err == nil {
+		f, _ := r.Float64()
+		return f
+	}
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+// checkArgCount checks the number of arguments of an operation or function
+func checkArgCount(operation Operation, argc int) error {
+	if IsFunction(string(operation)) {
+		return checkArity(string(operation), argc)
+	}
+	expected := 2
+	if operation.IsUnary() {
+		expected = 1
+	}
+	if argc != expected {
+		return fmt.Errorf("expected %d arguments for operation %s, got %d", expected, operation, argc)
+	}
+	return nil
+}
+
+// processFloat computes an operation or function with float64 arguments
+func processFloat(operation Operation, args []string) (float64, error) {
+	if err := checkArgCount(operation, len(args)); err != nil {
+		return 0, err
+	}
+
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid arg%d: %v", i+1, err)
+		}
+		values[i] = value
+	}
+
+	if IsFunction(string(operation)) {
+		return functions[string(operation)].Apply(values)
+	}
+	if operation.IsUnary() {
+		return applyOperation(operation, values[0], 0)
+	}
+	return applyOperation(operation, values[0], values[1])
+}
+
+// processExact computes an operation or function with exact rational arguments
+func processExact(operation Operation, args []string, precision int) (*big.Rat, error) {
+	if err := checkArgCount(operation, len(args)); err != nil {
+		return nil, err
+	}
+
+	values := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		value, err := parseRat(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arg%d: %v", i+1, err)
+		}
+		values[i] = value
+	}
+
+	if IsFunction(string(operation)) {
+		fn := functions[string(operation)]
+		if fn.Exact == nil {
+			return nil, fmt.Errorf("function %s is not supported in exact modes", operation)
+		}
+		return fn.Exact(values, precision)
+	}
+
+	switch operation {
+	case Addition:
+		return new(big.Rat).Add(values[0], values[1]), nil
+	case Subtraction:
+		return new(big.Rat).Sub(values[0], values[1]), nil
+	case Multiplication:
+		return new(big.Rat).Mul(values[0], values[1]), nil
+	case Division:
+		if values[1].Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return new(big.Rat).Quo(values[0], values[1]), nil
+	case Negation:
+		return new(big.Rat).Neg(values[0]), nil
+	case Exponentiation:
+		return ratPow(values[0], values[1])
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// ratPow raises base to an integer exponent exactly
+func ratPow(base, exp *big.Rat) (*big.Rat, error) {
+	if !exp.IsInt() {
+		return nil, fmt.Errorf("fractional exponents are not supported in exact modes")
+	}
+	if exp.Num().CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		return nil, fmt.Errorf("exponent too large")
+	}
+	if base.Sign() == 0 && exp.Sign() < 0 {
+		return nil, ErrDivisionByZero
+	}
+
+	// A b bit part raised to n has at least n*(b-1)+1 bits, too large results are not computed
+	n := new(big.Int).Abs(exp.Num())
+	for _, part := range []*big.Int{base.Num(), base.Denom()} {
+		if bits := part.BitLen(); bits > 1 && (bits-1)*int(n.Int64())+1 > maxExactBits {
+			return nil, ErrOutOfRange
+		}
+	}
+	num := new(big.Int).Exp(base.Num(), n, nil)
+	denom := new(big.Int).Exp(base.Denom(), n, nil)
+	if exp.Sign() < 0 {
+		num, denom = denom, num
+	}
+	// SetFrac requires a positive denominator
+	if denom.Sign() < 0 {
+		num.Neg(num)
+		denom.Neg(denom)
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
+
+// parseRat parses a decimal or fraction string into an exact rational
+func parseRat(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %q", value)
+	}
+	return r, nil
+}
+
+// formatExact encodes an exact result for the given mode
+func formatExact(r *big.Rat, mode NumericMode, precision int) string {
+	if mode == RationalMode {
+		return r.RatString()
+	}
+	return formatDecimal(r, precision)
+}
+
+// formatDecimal writes a rational as a decimal string. Terminating fractions are
+// written out exactly, others are rounded to precision decimal places.
+func formatDecimal(r *big.Rat, precision int) string {
+	digits, ok := terminatingDigits(r.Denom())
+	if !ok {
+		digits = precision
+	}
+
+	s := r.FloatString(digits)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// terminatingDigits returns the number of decimal places needed to write 1/denom
+// exactly, or false when the decimal expansion does not terminate
+func terminatingDigits(denom *big.Int) (int, bool) {
+	d := new(big.Int).Set(denom)
+	five := big.NewInt(5)
+	mod := new(big.Int)
+
+	twos, fives := 0, 0
+	for d.Bit(0) == 0 && d.Sign() != 0 {
+		d.Rsh(d, 1)
+		twos++
+	}
+	for {
+		q, m := new(big.Int).QuoRem(d, five, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		d = q
+		fives++
+	}
+
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// literals builds resolved task arguments
+func literals(values ...string) []Operand {
+	args := make([]Operand, len(values))
+	for i, value := range values {
+		args[i] = Operand{Value: Value(value)}
+	}
+	return args
+}
+
+func TestProcessTaskDecimal(t *testing.T) {
+	cases := []struct {
+		operation Operation
+		args      []string
+		expected  string
+	}{
+		{Addition, []string{"0.1", "0.2"}, "0.3"},
+		{Subtraction, []string{"0.3", "0.1"}, "0.2"},
+		{Multiplication, []string{"9007199254740993", "3"}, "27021597764222979"},
+		{Division, []string{"1", "8"}, "0.125"},
+		{Division, []string{"2", "3"}, "0.6666666667"},
+		{Negation, []string{"-0.5"}, "0.5"},
+		{Exponentiation, []string{"2", "64"}, "18446744073709551616"},
+		{Exponentiation, []string{"2", "-2"}, "0.25"},
+		{Exponentiation, []string{"-0.5", "3"}, "-0.125"},
+		{"sqrt", []string{"0.25"}, "0.5"},
+		{"sqrt", []string{"2"}, "1.4142135624"},
+		{"abs", []string{"-1e-30"}, "0.000000000000000000000000000001"},
+		{"min", []string{"0.3", "0.1", "0.2"}, "0.1"},
+		{"max", []string{"0.3", "0.1", "0.2"}, "0.3"},
+		{"round", []string{"2.5"}, "3"},
+		{"round", []string{"-2.5"}, "-3"},
+		{"round", []string{"1.005", "2"}, "1.01"},
+	}
+
+	for _, c := range cases {
+		task := &Task{Operation: c.operation, Args: literals(c.args...), Mode: DecimalMode, Precision: 10}
+		result, err := ProcessTask(task)
+		assert.NoError(t, err, c.operation)
+		assert.Equal(t, Value(c.expected), result, c.operation)
+	}
+
+	// Results that can not be represented exactly are rejected
+	for _, task := range []*Task{
+		{Operation: Division, Args: literals("1", "0")},
+		{Operation: Exponentiation, Args: literals("2", "0.5")},
+		{Operation: Exponentiation, Args: literals("2", "100000")},
+		{Operation: "log", Args: literals("2")},
+		{Operation: Addition, Args: literals("1")},
+	} {
+		task.Mode = DecimalMode
+		task.Precision = 10
+		_, err := ProcessTask(task)
+		assert.Error(t, err, task.Operation)
+	}
+
+	// The numerator and denominator of a result are bounded even when the exponent is small
+	for _, task := range []*Task{
+		{Operation: Exponentiation, Args: literals("1e10000", "4")},
+		{Operation: Exponentiation, Args: literals("1e-10000", "4")},
+		{Operation: Multiplication, Args: literals("1e20000", "1e20000")},
+		{Operation: Division, Args: literals("1e-20000", "1e20000")},
+	} {
+		task.Mode = RationalMode
+		_, err := ProcessTask(task)
+		assert.ErrorIs(t, err, ErrOutOfRange, task.Operation)
+	}
+	result, err := ProcessTask(&Task{Operation: Exponentiation, Args: literals("1e10000", "3"), Mode: RationalMode})
+	assert.NoError(t, err)
+	assert.Len(t, string(result), 30001)
+}
+
+func TestProcessTaskFloat(t *testing.T) {
+	// Results are encoded in the shortest form that parses back to the same float64
+	result, err := ProcessTask(&Task{Operation: Division, Args: literals("1", "1000000000"), Mode: FloatMode})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("1e-09"), result)
+
+	result, err = ProcessTask(&Task{Operation: Addition, Args: literals("0.1", "0.2"), Mode: FloatMode})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("0.30000000000000004"), result)
+
+	// Overflow can not be encoded and is reported as an error
+	_, err = ProcessTask(&Task{Operation: Multiplication, Args: literals("1e308", "10"), Mode: FloatMode})
+	assert.Error(t, err)
+}
+
+func TestServiceDecimalMode(t *testing.T) {
+	svc := NewService(OperationTimes{}, WithNumericMode(DecimalMode, 20))
+
+	id, err := svc.SubmitExpression("(0.1+0.2)*3")
+	assert.NoError(t, err)
+
+	// Run the tasks the way an agent would
+	for {
+		task, found := svc.GetTask()
+		if !found {
+			break
+		}
+		assert.Equal(t, DecimalMode, task.Mode)
+		assert.Equal(t, 20, task.Precision)
+		result, err := ProcessTask(task)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, result))
+	}
+
+	expr, _ := svc.GetExpression(id)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, "0.9", expr.Value)
+	assert.Equal(t, 0.9, *expr.Result)
+
+	// Functions without an exact implementation are rejected up front
+	_, err = svc.SubmitExpression("sin(1)")
+	assert.Error(t, err)
+
+	// The mode can also be chosen per expression
+	id, err = svc.SubmitExpressionWithOptions("sin(0)", ExpressionOptions{Mode: FloatMode})
+	assert.NoError(t, err)
+	expr, _ = svc.GetExpression(id)
+	assert.Equal(t, FloatMode, expr.Mode)
+
+	// Results must be numbers
+	svc.SubmitExpression("1+1")
+	task, _ := svc.GetTask()
+	assert.Error(t, svc.SetTaskResult(task.ID, task.LeaseID, "two"))
+}
+
+func TestServiceExponentLiterals(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	id, err := svc.SubmitExpression("1e+21")
+	assert.NoError(t, err)
+	expr, _ := svc.GetExpression(id)
+	assert.Equal(t, "1e+21", expr.Value)
+
+	// Literals reach agents as written
+	_, err = svc.SubmitExpression("2.5E3*1e-9")
+	assert.NoError(t, err)
+	task, _ := svc.GetTask()
+	assert.Equal(t, literals("2.5E3", "1e-9"), task.Args)
+	result, err := ProcessTask(task)
+	assert.NoError(t, err)
+	assert.Equal(t, Value("2.5e-06"), result)
+
+	id, err = svc.SubmitExpressionWithOptions("1e-3", ExpressionOptions{Mode: DecimalMode})
+	assert.NoError(t, err)
+	expr, _ = svc.GetExpression(id)
+	assert.Equal(t, "0.001", expr.Value)
+
+	// Numbers that can not be represented are rejected up front
+	_, err = svc.SubmitExpression("1e400+1")
+	assert.Error(t, err)
+	_, err = svc.SubmitExpressionWithOptions("1e100000*2", ExpressionOptions{Mode: RationalMode})
+	assert.Error(t, err)
+	_, err = svc.SubmitExpressionWithOptions("1e10000*2", ExpressionOptions{Mode: RationalMode})
+	assert.NoError(t, err)
+}
+
+func TestProcessTaskRational(t *testing.T) {
+	cases := []struct {
+		operation Operation
+		args      []string
+		expected  string
+	}{
+		{Division, []string{"1", "3"}, "1/3"},
+		{Addition, []string{"1/3", "1/6"}, "1/2"},
+		{Multiplication, []string{"2/3", "3/2"}, "1"},
+		{Addition, []string{"0.1", "1/5"}, "3/10"},
+		{Exponentiation, []string{"2/3", "-2"}, "9/4"},
+		{"sqrt", []string{"4/9"}, "2/3"},
+		{"round", []string{"7/2"}, "4"},
+	}
+
+	for _, c := range cases {
+		task := &Task{Operation: c.operation, Args: literals(c.args...), Mode: RationalMode}
+		result, err := ProcessTask(task)
+		assert.NoError(t, err, c.operation)
+		assert.Equal(t, Value(c.expected), result, c.operation)
+	}
+
+	// Irrational roots can not be represented
+	_, err := ProcessTask(&Task{Operation: "sqrt", Args: literals("2"), Mode: RationalMode})
+	assert.Error(t, err)
+}
+
+func TestServiceRationalMode(t *testing.T) {
+	svc := NewService(OperationTimes{}, WithNumericMode(FloatMode, 5))
+
+	id, err := svc.SubmitExpressionWithOptions("1/3+1/3", ExpressionOptions{Mode: RationalMode})
+	assert.NoError(t, err)
+
+	for {
+		task, found := svc.GetTask()
+		if !found {
+			break
+		}
+		result, err := ProcessTask(task)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, result))
+	}
+
+	expr, _ := svc.GetExpression(id)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, "2/3", expr.Value)
+	assert.Equal(t, "0.66667", expr.Decimal)
+	assert.InDelta(t, 2.0/3, *expr.Result, 1e-15)
+}
+
+func TestValueJSON(t *testing.T) {
+	// Decimals are written as JSON numbers, fractions as strings
+	data, err := json.Marshal([]Value{"1.5", "-2", "1e-09", "1/3"})
+	assert.NoError(t, err)
+	assert.Equal(t, `[1.5,-2,1e-09,"1/3"]`, string(data))
+
+	var values []Value
+	assert.NoError(t, json.Unmarshal([]byte(`[0.30000000000000000001, "1/3", 4]`), &values))
+	assert.Equal(t, []Value{"0.30000000000000000001", "1/3", "4"}, values)
+
+	assert.Error(t, json.Unmarshal([]byte(`[true]`), &values))
+}
+
+func TestExactValueJSON(t *testing.T) {
+	result := Value("27021597764222979")
+	task := Task{ID: "task_1", Args: []Operand{{Value: "9007199254740993"}, {Ref: "task_0", Value: "3"}}, Result: &result, Mode: DecimalMode}
+
+	// Exact values are written as JSON strings, float values as numbers
+	data, err := json.Marshal(task)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"args":[{"value":"9007199254740993"},{"ref":"task_0","value":"3"}]`)
+	assert.Contains(t, string(data), `"result":"27021597764222979"`)
+	assert.Contains(t, string(data), `"mode":"decimal"`)
+
+	// Both forms read back to the same task
+	var decoded Task
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, task.Args, decoded.Args)
+	assert.Equal(t, result, *decoded.Result)
+
+	task.Mode = FloatMode
+	data, _ = json.Marshal(&task)
+	assert.Contains(t, string(data), `"args":[{"value":9007199254740993},{"ref":"task_0","value":3}]`)
+
+	data, _ = json.Marshal(newTaskNode(&Task{Args: literals("1/3"), Result: &result, Mode: RationalMode}, nil, nil))
+	assert.Contains(t, string(data), `"args":[{"value":"1/3"}]`)
+	assert.Contains(t, string(data), `"result":"27021597764222979"`)
+
+	data, _ = json.Marshal(Event{Type: EventTaskCompleted, Result: "0.5", Mode: DecimalMode})
+	assert.Contains(t, string(data), `"result":"0.5"`)
+	data, _ = json.Marshal(Event{Type: EventTaskCompleted, Result: "0.5", Mode: FloatMode})
+	assert.Contains(t, string(data), `"result":0.5`)
+}
+
+func TestServiceOperandResolution(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	svc.SubmitExpression("(1+2)*3")
+	task, _ := svc.GetTask()
+	sum := task.ID
+	assert.NoError(t, svc.SetTaskResult(sum, task.LeaseID, "1e-09"))
+
+	// The dependent task keeps the reference and gets the exact reported value
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, []Operand{{Ref: sum, Value: "1e-09"}, {Value: "3"}}, task.Args)
+
+	// Unresolved operands can not be processed
+	_, err := ProcessTask(&Task{Operation: Negation, Args: []Operand{{Ref: "task_0"}}})
+	assert.Error(t, err)
+}
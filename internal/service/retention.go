@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy decides how long finished expressions and their tasks are kept.
+// MaxAge removes expressions that finished longer ago, StatusMaxAge overrides it
+// for single statuses, e.g. to keep failed expressions longer, and MaxCount keeps
+// at most that many finished expressions, removing those that finished first.
+// Zero values keep expressions forever.
+type RetentionPolicy struct {
+	MaxAge       time.Duration
+	StatusMaxAge map[ExpressionStatus]time.Duration
+	MaxCount     int
+}
+
+// maxAge returns how long an expression that finished in the given status is kept
+func (p RetentionPolicy) maxAge(status ExpressionStatus) time.Duration {
+	if age, ok := p.StatusMaxAge[status]; ok {
+		return age
+	}
+	return p.MaxAge
+}
+
+// GCStats counts what the garbage collector removed since the service started
+type GCStats struct {
+	Runs                 int64                      `json:"runs"`
+	ExpressionsCollected int64                      `json:"expressions_collected"`
+	TasksCollected       int64                      `json:"tasks_collected"`
+	CollectedByStatus    map[ExpressionStatus]int64 `json:"collected_by_status"`
+	LastRun              *time.Time                 `json:"last_run,omitempty"`
+	LastCollected        int                        `json:"last_collected"`
+}
+
+// Metrics describes the amount of state held by the service
+type Metrics struct {
+	Expressions         int                      `json:"expressions"`
+	ExpressionsByStatus map[ExpressionStatus]int `json:"expressions_by_status"`
+	Tasks               int                      `json:"tasks"`
+	QueuedTasks         int                      `json:"queued_tasks"`
+	IdempotencyKeys     int                      `json:"idempotency_keys"`
+	GC                  GCStats                  `json:"gc"`
+}
+
+// WithRetention sets the policy for removing finished expressions, see RunCollector
+func WithRetention(policy RetentionPolicy) Option {
+	return func(s *Service) {
+		s.retention = policy
+	}
+}
+
+// CollectGarbage removes the finished expressions the retention policy no longer
+// keeps together with their tasks and dependency bookkeeping, and returns the
+// number of expressions removed. Expired idempotency keys are forgotten as well.
+func (s *Service) CollectGarbage() int {
+	s.mu.Lock()
+	defer s.unlock()
+
+	now := s.now()
+	s.expireIdempotencyKeys(now)
+
+	collect := make(map[string]bool)
+	var kept []*ExpressionData
+	for id, expr := range s.expressions {
+		if !expr.Status.Finished() {
+			continue
+		}
+		if age := s.retention.maxAge(expr.Status); age > 0 && now.Sub(finishedAt(expr)) >= age {
+			collect[id] = true
+		} else {
+			kept = append(kept, expr)
+		}
+	}
+
+	// Beyond the maximum count the expressions that finished first go
+	if limit := s.retention.MaxCount; limit > 0 && len(kept) > limit {
+		sort.Slice(kept, func(i, j int) bool { return finishedAt(kept[i]).Before(finishedAt(kept[j])) })
+		for _, expr := range kept[:len(kept)-limit] {
+			collect[expr.ID] = true
+		}
+	}
+
+	tasks := 0
+	if len(collect) > 0 {
+		for taskID, task := range s.tasks {
+			if !collect[task.ExpressionID] {
+				continue
+			}
+			delete(s.tasks, taskID)
+			delete(s.completedTasks, taskID)
+			delete(s.dependencyGraph, taskID)
+			delete(s.reverseDependencies, taskID)
+			s.scheduler.Remove(taskID)
+			s.changes.task(taskID)
+			tasks++
+		}
+	}
+
+	if s.gcStats.CollectedByStatus == nil {
+		s.gcStats.CollectedByStatus = make(map[ExpressionStatus]int64)
+	}
+	for id := range collect {
+		s.gcStats.CollectedByStatus[s.expressions[id].Status]++
+		s.forgetIdempotencyKey(s.expressions[id])
+		delete(s.expressions, id)
+		s.changes.expression(id)
+	}
+	s.gcStats.Runs++
+	s.gcStats.ExpressionsCollected += int64(len(collect))
+	s.gcStats.TasksCollected += int64(tasks)
+	s.gcStats.LastRun = &now
+	s.gcStats.LastCollected = len(collect)
+
+	if len(collect) > 0 {
+		log.Printf("Collected %d finished expressions with %d tasks", len(collect), tasks)
+	}
+	return len(collect)
+}
+
+// finishedAt returns when an expression finished, expressions saved before the
+// time was recorded fall back to their completion or submission time
+func finishedAt(expr *ExpressionData) time.Time {
+	if expr.FinishedAt != nil {
+		return *expr.FinishedAt
+	}
+	if expr.CompletedAt != nil {
+		return *expr.CompletedAt
+	}
+	return expr.SubmittedAt
+}
+
+// RunCollector collects garbage every interval until the context is done
+func (s *Service) RunCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.CollectGarbage()
+		}
+	}
+}
+
+// GetMetrics returns the number of expressions and tasks held and the garbage collector statistics
+func (s *Service) GetMetrics() Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := Metrics{
+		Expressions:         len(s.expressions),
+		ExpressionsByStatus: make(map[ExpressionStatus]int),
+		Tasks:               len(s.tasks),
+		QueuedTasks:         s.scheduler.Len(),
+		IdempotencyKeys:     len(s.idempotencyKeys),
+		GC:                  s.gcStats,
+	}
+	for _, expr := range s.expressions {
+		metrics.ExpressionsByStatus[expr.Status]++
+	}
+	metrics.GC.CollectedByStatus = make(map[ExpressionStatus]int64)
+	for status, n := range s.gcStats.CollectedByStatus {
+		metrics.GC.CollectedByStatus[status] = n
+	}
+	return metrics
+}
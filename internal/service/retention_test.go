@@ -0,0 +1,74 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceCollectGarbage(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	svc := NewService(OperationTimes{}, WithStore(store), WithRetention(RetentionPolicy{
+		MaxAge:       time.Minute,
+		StatusMaxAge: map[ExpressionStatus]time.Duration{Failed: time.Hour},
+	}))
+	svc.now = func() time.Time { return now }
+
+	completedID, _ := svc.SubmitExpression("1+2")
+	task, _ := svc.GetTask()
+	svc.SetTaskResult(task.ID, task.LeaseID, "3")
+	failedID, _ := svc.SubmitExpression("1/0")
+	task, _ = svc.GetTask()
+	svc.SetTaskError(task.ID, task.LeaseID, FailureDivisionByZero, "division by zero")
+	runningID, _ := svc.SubmitExpression("2*3")
+
+	// Nothing is old enough yet
+	assert.Equal(t, 0, svc.CollectGarbage())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, svc.CollectGarbage())
+
+	_, found := svc.GetExpression(completedID)
+	assert.False(t, found)
+	_, found = svc.GetExpression(failedID)
+	assert.True(t, found)
+	_, found = svc.GetExpression(runningID)
+	assert.True(t, found)
+
+	// The tasks of the collected expression are gone, also from the store
+	assert.Len(t, svc.tasks, 2)
+	assert.Len(t, svc.completedTasks, 0)
+	assert.ErrorIs(t, svc.SetTaskResult("task_1", "", "3"), ErrTaskNotFound)
+	expressions, tasks, _ := store.Load()
+	assert.Len(t, expressions, 2)
+	assert.Len(t, tasks, 2)
+
+	metrics := svc.GetMetrics()
+	assert.Equal(t, 2, metrics.Expressions)
+	assert.Equal(t, 1, metrics.QueuedTasks)
+	assert.Equal(t, int64(2), metrics.GC.Runs)
+	assert.Equal(t, int64(1), metrics.GC.ExpressionsCollected)
+	assert.Equal(t, int64(1), metrics.GC.TasksCollected)
+	assert.Equal(t, int64(1), metrics.GC.CollectedByStatus[Completed])
+}
+
+func TestServiceCollectGarbageMaxCount(t *testing.T) {
+	now := time.Now()
+	svc := NewService(OperationTimes{}, WithRetention(RetentionPolicy{MaxCount: 2}))
+	svc.now = func() time.Time { return now }
+
+	var ids []string
+	for _, expression := range []string{"1", "2", "3"} {
+		id, _ := svc.SubmitExpression(expression)
+		ids = append(ids, id)
+		now = now.Add(time.Second)
+	}
+
+	// The expression that finished first is removed
+	assert.Equal(t, 1, svc.CollectGarbage())
+	_, found := svc.GetExpression(ids[0])
+	assert.False(t, found)
+	assert.Len(t, svc.GetExpressions(), 2)
+}
@@ -0,0 +1,489 @@
+package service
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// Scheduler decides the order in which ready tasks are handed out to agents.
+// It is guarded by the lock of the Service that owns it.
+type Scheduler interface {
+	// Add queues a task whose operands are all known
+	Add(task *Task)
+	// Remove drops a queued task, e.g. when its expression fails
+	Remove(taskID string)
+	// Next removes and returns the first queued task in scheduling order whose
+	// operation is one of ops
+	Next(ops []Operation) (*Task, bool)
+	// Len returns the number of queued tasks
+	Len() int
+}
+
+// Scheduling policies selectable by name
+const (
+	FIFOPolicy     = "fifo"
+	PriorityPolicy = "priority"
+	FairPolicy     = "fair"
+	CriticalPolicy = "critical"
+)
+
+// NewScheduler creates the scheduler for a policy name, an empty name means FIFOPolicy
+func NewScheduler(policy string) (Scheduler, error) {
+	switch policy {
+	case "", FIFOPolicy:
+		return NewFIFOScheduler(), nil
+	case PriorityPolicy:
+		return NewPriorityScheduler(), nil
+	case FairPolicy:
+		return NewFairScheduler(), nil
+	case CriticalPolicy:
+		return NewCriticalPathScheduler(), nil
+	default:
+		return nil, fmt.Errorf("unknown scheduling policy: %s", policy)
+	}
+}
+
+// queued is a task in a queue with the number it was queued under
+type queued struct {
+	task  *Task
+	seq   uint64
+	index int
+}
+
+// taskHeap orders queued tasks by a policy, ties go to the task that became
+// ready first. It implements heap.Interface.
+type taskHeap struct {
+	entries []*queued
+	less    func(a, b *Task) bool
+}
+
+func (h taskHeap) Len() int { return len(h.entries) }
+
+func (h taskHeap) Less(i, j int) bool {
+	return h.before(h.entries[i], h.entries[j])
+}
+
+// before reports whether a goes before b, the entries may be in different heaps with the same order
+func (h taskHeap) before(a, b *queued) bool {
+	if h.less != nil {
+		if h.less(a.task, b.task) {
+			return true
+		}
+		if h.less(b.task, a.task) {
+			return false
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *taskHeap) Push(x any) {
+	entry := x.(*queued)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *taskHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+	return entry
+}
+
+// queue holds ready tasks in scheduling order, adding, removing and taking
+// the first task cost O(log n)
+type queue struct {
+	heap taskHeap
+	byID map[string]*queued
+	seq  uint64
+}
+
+// newQueue creates a queue ordered by less, nil keeps the order the tasks became ready in
+func newQueue(less func(a, b *Task) bool) queue {
+	return queue{
+		heap: taskHeap{less: less},
+		byID: make(map[string]*queued),
+	}
+}
+
+func (q *queue) Add(task *Task) {
+	q.seq++
+	q.push(task, q.seq)
+}
+
+// push queues a task under the given number, a task that is already queued keeps its place
+func (q *queue) push(task *Task, seq uint64) {
+	if _, ok := q.byID[task.ID]; ok {
+		return
+	}
+	entry := &queued{task: task, seq: seq}
+	heap.Push(&q.heap, entry)
+	q.byID[task.ID] = entry
+}
+
+func (q *queue) Remove(taskID string) {
+	if entry, ok := q.byID[taskID]; ok {
+		heap.Remove(&q.heap, entry.index)
+		delete(q.byID, taskID)
+	}
+}
+
+func (q *queue) Len() int {
+	return q.heap.Len()
+}
+
+// first returns the number of the first queued task
+func (q *queue) first() uint64 {
+	return q.heap.entries[0].seq
+}
+
+// peek returns the first queued task without removing it
+func (q *queue) peek() *queued {
+	return q.heap.entries[0]
+}
+
+// pop removes and returns the first queued task
+func (q *queue) pop() *Task {
+	entry := heap.Pop(&q.heap).(*queued)
+	delete(q.byID, entry.task.ID)
+	return entry.task
+}
+
+// opQueues holds the ready tasks in one queue per operation, so that an agent
+// only ever looks at the first task of each operation it can run
+type opQueues struct {
+	queues map[Operation]*queue
+	byID   map[string]Operation
+	less   func(a, b *Task) bool
+	seq    uint64
+}
+
+// newOpQueues creates queues ordered by less, nil keeps the order the tasks became ready in
+func newOpQueues(less func(a, b *Task) bool) opQueues {
+	return opQueues{
+		queues: make(map[Operation]*queue),
+		byID:   make(map[string]Operation),
+		less:   less,
+	}
+}
+
+func (q *opQueues) Add(task *Task) {
+	if _, ok := q.byID[task.ID]; ok {
+		return
+	}
+	ops, ok := q.queues[task.Operation]
+	if !ok {
+		queue := newQueue(q.less)
+		ops = &queue
+		q.queues[task.Operation] = ops
+	}
+	q.seq++
+	ops.push(task, q.seq)
+	q.byID[task.ID] = task.Operation
+}
+
+func (q *opQueues) Remove(taskID string) {
+	op, ok := q.byID[taskID]
+	if !ok {
+		return
+	}
+	q.queues[op].Remove(taskID)
+	delete(q.byID, taskID)
+	if q.queues[op].Len() == 0 {
+		delete(q.queues, op)
+	}
+}
+
+func (q *opQueues) Len() int {
+	return len(q.byID)
+}
+
+// Next compares the first tasks of the given operations and takes the one that goes first
+func (q *opQueues) Next(ops []Operation) (*Task, bool) {
+	var best *queue
+	for _, op := range ops {
+		candidate, ok := q.queues[op]
+		if ok && (best == nil || candidate.heap.before(candidate.peek(), best.peek())) {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+
+	task := best.pop()
+	delete(q.byID, task.ID)
+	if best.Len() == 0 {
+		delete(q.queues, task.Operation)
+	}
+	return task, true
+}
+
+// FIFOScheduler hands out tasks in the order they became ready
+type FIFOScheduler struct {
+	opQueues
+}
+
+// NewFIFOScheduler creates a first in, first out scheduler
+func NewFIFOScheduler() *FIFOScheduler {
+	return &FIFOScheduler{opQueues: newOpQueues(nil)}
+}
+
+// PriorityScheduler hands out tasks of expressions with a higher priority first,
+// tasks of the same priority in the order they became ready
+type PriorityScheduler struct {
+	opQueues
+}
+
+// NewPriorityScheduler creates a strict priority scheduler
+func NewPriorityScheduler() *PriorityScheduler {
+	return &PriorityScheduler{opQueues: newOpQueues(func(a, b *Task) bool { return a.Priority > b.Priority })}
+}
+
+// CriticalPathScheduler hands out the task with the longest remaining path to the
+// root of its expression first (the upward rank of HEFT list scheduling), so that
+// deep branches start early and expressions finish close to their critical path.
+// Tasks of the same rank go in the order they became ready.
+type CriticalPathScheduler struct {
+	opQueues
+}
+
+// NewCriticalPathScheduler creates a critical path scheduler
+func NewCriticalPathScheduler() *CriticalPathScheduler {
+	return &CriticalPathScheduler{opQueues: newOpQueues(func(a, b *Task) bool { return a.Rank > b.Rank })}
+}
+
+// FairScheduler shares agents between expressions in proportion to their priority,
+// so that a large expression can not starve small ones. Every expression has a
+// virtual time that advances by 1/weight for each task handed out, and the task of
+// the expression with the smallest virtual time goes first. The weight is the
+// priority of the expression, at least 1.
+//
+// Every expression has a queue of its tasks for each operation, and the queues of
+// an operation are kept in a heap by virtual time, so handing out a task costs
+// O(log n) for each operation the agent can run.
+type FairScheduler struct {
+	ops     map[Operation]*flowHeap
+	byExpr  map[string]map[Operation]*flow
+	byTask  map[string]*flow
+	idle    idleHeap
+	virtual map[string]float64
+	clock   float64
+	seq     uint64
+	queued  int
+}
+
+// flow is the queue of the tasks of an expression with one operation
+type flow struct {
+	exprID string
+	op     Operation
+	tasks  queue
+	index  int
+}
+
+// NewFairScheduler creates a weighted fair scheduler
+func NewFairScheduler() *FairScheduler {
+	return &FairScheduler{
+		ops:     make(map[Operation]*flowHeap),
+		byExpr:  make(map[string]map[Operation]*flow),
+		byTask:  make(map[string]*flow),
+		virtual: make(map[string]float64),
+	}
+}
+
+// Add queues a task, an expression that was idle starts at the current virtual time
+// instead of catching up on the share it did not use
+func (s *FairScheduler) Add(task *Task) {
+	if _, ok := s.byTask[task.ID]; ok {
+		return
+	}
+
+	if s.virtual[task.ExpressionID] < s.clock {
+		s.virtual[task.ExpressionID] = s.clock
+	}
+	flows, ok := s.byExpr[task.ExpressionID]
+	if !ok {
+		flows = make(map[Operation]*flow)
+		s.byExpr[task.ExpressionID] = flows
+	}
+	flowsOfOp, ok := s.ops[task.Operation]
+	if !ok {
+		flowsOfOp = &flowHeap{virtual: s.virtual}
+		s.ops[task.Operation] = flowsOfOp
+	}
+
+	f, ok := flows[task.Operation]
+	if !ok {
+		f = &flow{exprID: task.ExpressionID, op: task.Operation, tasks: newQueue(nil)}
+		flows[task.Operation] = f
+	}
+	s.seq++
+	f.tasks.push(task, s.seq)
+	s.byTask[task.ID] = f
+	s.queued++
+	if ok {
+		heap.Fix(flowsOfOp, f.index)
+	} else {
+		heap.Push(flowsOfOp, f)
+	}
+}
+
+// Remove drops a queued task
+func (s *FairScheduler) Remove(taskID string) {
+	f, ok := s.byTask[taskID]
+	if !ok {
+		return
+	}
+	f.tasks.Remove(taskID)
+	delete(s.byTask, taskID)
+	s.queued--
+	if f.tasks.Len() == 0 {
+		s.drop(f)
+	} else {
+		heap.Fix(s.ops[f.op], f.index)
+	}
+}
+
+// Len returns the number of queued tasks
+func (s *FairScheduler) Len() int {
+	return s.queued
+}
+
+// Next returns the oldest task, of one of the given operations, of the expression
+// that is furthest behind its share
+func (s *FairScheduler) Next(ops []Operation) (*Task, bool) {
+	var best *flow
+	for _, op := range ops {
+		flows, ok := s.ops[op]
+		if ok && (best == nil || flows.before(flows.flows[0], best)) {
+			best = flows.flows[0]
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+
+	task := best.tasks.pop()
+	delete(s.byTask, task.ID)
+	s.queued--
+
+	s.clock = s.virtual[best.exprID]
+	weight := task.Priority
+	if weight < 1 {
+		weight = 1
+	}
+	s.virtual[best.exprID] += 1 / float64(weight)
+
+	// The virtual time moved on, so every queue of the expression takes its new place
+	if best.tasks.Len() == 0 {
+		s.drop(best)
+	}
+	for _, f := range s.byExpr[best.exprID] {
+		heap.Fix(s.ops[f.op], f.index)
+	}
+	s.forgetIdle()
+	return task, true
+}
+
+// drop forgets an empty queue, and the expression too once it has no queued tasks left
+func (s *FairScheduler) drop(f *flow) {
+	flows := s.ops[f.op]
+	heap.Remove(flows, f.index)
+	if flows.Len() == 0 {
+		delete(s.ops, f.op)
+	}
+	delete(s.byExpr[f.exprID], f.op)
+	if len(s.byExpr[f.exprID]) == 0 {
+		s.retire(f.exprID)
+	}
+}
+
+// retire forgets an expression without queued tasks, its virtual time is kept
+// until the clock catches up with it
+func (s *FairScheduler) retire(exprID string) {
+	delete(s.byExpr, exprID)
+	heap.Push(&s.idle, idleExpression{exprID: exprID, virtual: s.virtual[exprID]})
+}
+
+// forgetIdle drops the virtual time of expressions without queued tasks that are
+// not ahead of the clock, they would start at the clock again anyway
+func (s *FairScheduler) forgetIdle() {
+	for s.idle.Len() > 0 && s.idle[0].virtual <= s.clock {
+		entry := heap.Pop(&s.idle).(idleExpression)
+		// The expression may have queued tasks again, or gone idle again later
+		if _, active := s.byExpr[entry.exprID]; !active && s.virtual[entry.exprID] == entry.virtual {
+			delete(s.virtual, entry.exprID)
+		}
+	}
+}
+
+// flowHeap orders the queues of expressions by virtual time, ties go to the
+// expression whose first task became ready first. It implements heap.Interface.
+type flowHeap struct {
+	flows   []*flow
+	virtual map[string]float64
+}
+
+func (h flowHeap) Len() int { return len(h.flows) }
+
+func (h flowHeap) Less(i, j int) bool {
+	return h.before(h.flows[i], h.flows[j])
+}
+
+// before reports whether queue a goes before queue b, they may be in the heaps of different operations
+func (h flowHeap) before(a, b *flow) bool {
+	if va, vb := h.virtual[a.exprID], h.virtual[b.exprID]; va != vb {
+		return va < vb
+	}
+	return a.tasks.first() < b.tasks.first()
+}
+
+func (h flowHeap) Swap(i, j int) {
+	h.flows[i], h.flows[j] = h.flows[j], h.flows[i]
+	h.flows[i].index = i
+	h.flows[j].index = j
+}
+
+func (h *flowHeap) Push(x any) {
+	f := x.(*flow)
+	f.index = len(h.flows)
+	h.flows = append(h.flows, f)
+}
+
+func (h *flowHeap) Pop() any {
+	last := len(h.flows) - 1
+	f := h.flows[last]
+	h.flows[last] = nil
+	h.flows = h.flows[:last]
+	return f
+}
+
+// idleExpression is an expression without queued tasks and its virtual time when it went idle
+type idleExpression struct {
+	exprID  string
+	virtual float64
+}
+
+// idleHeap orders idle expressions by virtual time. It implements heap.Interface.
+type idleHeap []idleExpression
+
+func (h idleHeap) Len() int           { return len(h) }
+func (h idleHeap) Less(i, j int) bool { return h[i].virtual < h[j].virtual }
+func (h idleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *idleHeap) Push(x any) {
+	*h = append(*h, x.(idleExpression))
+}
+
+func (h *idleHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
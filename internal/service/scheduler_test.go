@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drain hands out every queued task and returns the expression IDs in order
+func drain(s Scheduler) []string {
+	var order []string
+	for {
+		task, found := s.Next(SupportedOperations())
+		if !found {
+			return order
+		}
+		order = append(order, task.ExpressionID)
+	}
+}
+
+func TestFIFOScheduler(t *testing.T) {
+	s := NewFIFOScheduler()
+	s.Add(&Task{ID: "task_1", ExpressionID: "a", Operation: Addition, Priority: 1})
+	s.Add(&Task{ID: "task_2", ExpressionID: "b", Operation: Multiplication, Priority: 5})
+	s.Add(&Task{ID: "task_3", ExpressionID: "c", Operation: Addition})
+	s.Remove("task_3")
+	assert.Equal(t, 2, s.Len())
+
+	// Tasks the agent can not run are skipped but keep their place
+	task, found := s.Next([]Operation{Multiplication, Division})
+	assert.True(t, found)
+	assert.Equal(t, "task_2", task.ID)
+
+	assert.Equal(t, []string{"a"}, drain(s))
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	s := NewPriorityScheduler()
+	s.Add(&Task{ID: "task_1", ExpressionID: "low", Operation: Addition})
+	s.Add(&Task{ID: "task_2", ExpressionID: "high", Operation: Addition, Priority: 10})
+	s.Add(&Task{ID: "task_3", ExpressionID: "mid", Operation: Addition, Priority: 5})
+	s.Add(&Task{ID: "task_4", ExpressionID: "high2", Operation: Addition, Priority: 10})
+
+	assert.Equal(t, []string{"high", "high2", "mid", "low"}, drain(s))
+}
+
+func TestFairScheduler(t *testing.T) {
+	s := NewFairScheduler()
+
+	// A large expression is queued before two small ones
+	for i := 0; i < 4; i++ {
+		s.Add(&Task{ID: fmt.Sprintf("big_%d", i), ExpressionID: "big", Operation: Addition})
+	}
+	s.Add(&Task{ID: "small", ExpressionID: "small", Operation: Addition})
+	s.Add(&Task{ID: "heavy_1", ExpressionID: "heavy", Operation: Addition, Priority: 2})
+	s.Add(&Task{ID: "heavy_2", ExpressionID: "heavy", Operation: Addition, Priority: 2})
+
+	// The expressions take turns, the one with weight 2 gets twice the share
+	assert.Equal(t, []string{"big", "small", "heavy", "heavy", "big", "big", "big"}, drain(s))
+}
+
+func TestFairSchedulerRemove(t *testing.T) {
+	s := NewFairScheduler()
+	s.Add(&Task{ID: "a_1", ExpressionID: "a", Operation: Addition})
+	s.Add(&Task{ID: "a_2", ExpressionID: "a", Operation: Addition})
+	s.Add(&Task{ID: "b_1", ExpressionID: "b", Operation: Multiplication})
+	s.Remove("a_1")
+	s.Remove("unknown")
+	assert.Equal(t, 2, s.Len())
+
+	// Tasks the agent can not run stay queued
+	task, found := s.Next([]Operation{Multiplication})
+	assert.True(t, found)
+	assert.Equal(t, "b_1", task.ID)
+	_, found = s.Next([]Operation{Multiplication})
+	assert.False(t, found)
+
+	s.Remove("a_2")
+	assert.Equal(t, 0, s.Len())
+	assert.Empty(t, drain(s))
+}
+
+func TestSchedulerManyTasks(t *testing.T) {
+	// Draining a large queue takes O(n log n), not O(n²), also when the agent runs a single operation
+	ops := SupportedOperations()
+	s := NewFairScheduler()
+	for i := 0; i < 20000; i++ {
+		s.Add(&Task{ID: fmt.Sprintf("task_%d", i), ExpressionID: fmt.Sprintf("expr_%d", i%100), Operation: ops[i%2]})
+	}
+	for i := 0; i < 10000; i++ {
+		task, found := s.Next([]Operation{Subtraction})
+		assert.True(t, found)
+		assert.Equal(t, Subtraction, task.Operation)
+	}
+	_, found := s.Next([]Operation{Subtraction})
+	assert.False(t, found)
+	assert.Equal(t, 10000, s.Len())
+
+	for _, s := range []Scheduler{NewFIFOScheduler(), NewPriorityScheduler(), NewFairScheduler(), NewCriticalPathScheduler()} {
+		for i := 0; i < 20000; i++ {
+			s.Add(&Task{ID: fmt.Sprintf("task_%d", i), ExpressionID: fmt.Sprintf("expr_%d", i%100), Operation: ops[i%len(ops)], Priority: i % 3, Rank: i % 7})
+		}
+		s.Remove("task_10")
+		assert.Len(t, drain(s), 19999)
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	for _, policy := range []string{"", FIFOPolicy, PriorityPolicy, FairPolicy} {
+		s, err := NewScheduler(policy)
+		assert.NoError(t, err)
+		assert.NotNil(t, s)
+	}
+
+	_, err := NewScheduler("random")
+	assert.EqualError(t, err, "unknown scheduling policy: random")
+}
+
+func TestServicePriority(t *testing.T) {
+	svc := NewService(OperationTimes{}, WithScheduler(NewPriorityScheduler()))
+
+	svc.SubmitExpression("1+1")
+	urgent, _ := svc.SubmitExpressionWithOptions("2+2", ExpressionOptions{Priority: 10})
+
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, urgent, task.ExpressionID)
+	assert.Equal(t, 10, task.Priority)
+}
+
+func TestServiceCriticalPath(t *testing.T) {
+	svc := NewService(OperationTimes{Addition: 100, Multiplication: 250}, WithScheduler(NewCriticalPathScheduler()))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	// The chain of additions is the critical path: 4 additions of 100ms
+	exprID, _ := svc.SubmitExpression("5*6+(1+2+3+4)")
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, int64(400), expr.MinMakespan)
+	assert.Nil(t, expr.Makespan)
+
+	// The first addition of the chain goes before the multiplication that is ready earlier
+	task, _ := svc.GetTask()
+	assert.Equal(t, Addition, task.Operation)
+	assert.Equal(t, 400, task.Rank)
+	mul, _ := svc.GetTask()
+	assert.Equal(t, Multiplication, mul.Operation)
+	assert.Equal(t, 350, mul.Rank)
+
+	now = now.Add(250 * time.Millisecond)
+	svc.SetTaskResult(mul.ID, mul.LeaseID, "30")
+	for task != nil {
+		// Compute without the simulated delay
+		result, err := processFloat(task.Operation, []string{task.Args[0].String(), task.Args[1].String()})
+		assert.NoError(t, err)
+		now = now.Add(100 * time.Millisecond)
+		assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, Value(fmt.Sprint(result))))
+		task, _ = svc.GetTask()
+	}
+
+	expr, _ = svc.GetExpression(exprID)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, "40", expr.Value)
+	assert.Equal(t, int64(650), *expr.Makespan)
+	assert.Equal(t, now, *expr.CompletedAt)
+}
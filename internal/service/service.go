@@ -1,13 +1,18 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/w0ikid/megacalc/internal/ast"
 )
 
 // Operation represents a mathematical operation
@@ -18,8 +23,19 @@ const (
 	Subtraction    Operation = "-"
 	Multiplication Operation = "*"
 	Division       Operation = "/"
+	Negation       Operation = "neg"
+	Exponentiation Operation = "^"
 )
 
+// SupportedOperations returns every operation and function this build can compute
+func SupportedOperations() []Operation {
+	ops := []Operation{Addition, Subtraction, Multiplication, Division, Negation, Exponentiation}
+	for _, name := range FunctionNames() {
+		ops = append(ops, Operation(name))
+	}
+	return ops
+}
+
 // ExpressionStatus represents the status of an expression evaluation
 type ExpressionStatus string
 
@@ -28,92 +44,319 @@ const (
 	InProcess ExpressionStatus = "in_process"
 	Completed ExpressionStatus = "completed"
 	Failed    ExpressionStatus = "failed"
+	Cancelled ExpressionStatus = "cancelled"
 )
 
-// ExpressionData represents an expression with its evaluation status
+// Finished reports whether an expression in this status gets no further updates
+func (st ExpressionStatus) Finished() bool {
+	return st == Completed || st == Failed || st == Cancelled
+}
+
+// ExpressionData represents an expression with its evaluation status.
+// MinMakespan is the critical path in milliseconds, the time the expression takes
+// with unlimited agents, Makespan is the actual time from submission to completion.
+// FinishedAt is set when the expression completes, fails or is cancelled.
+// IdempotencyKey and Fingerprint are the idempotency key it was submitted under
+// and the fingerprint of that request, they are stored so the key survives a restart.
 type ExpressionData struct {
-	ID         string           `json:"id"`
-	Expression string           `json:"expression"`
-	Status     ExpressionStatus `json:"status"`
-	Result     *float64         `json:"result,omitempty"`
+	ID          string           `json:"id"`
+	Expression  string           `json:"expression"`
+	Status      ExpressionStatus `json:"status"`
+	Result      *float64         `json:"result,omitempty"`
+	Value       string           `json:"value,omitempty"`
+	Decimal     string           `json:"decimal,omitempty"`
+	Mode        NumericMode      `json:"mode"`
+	Priority    int              `json:"priority,omitempty"`
+	MinMakespan int64            `json:"min_makespan_ms"`
+	Makespan    *int64           `json:"makespan_ms,omitempty"`
+	SubmittedAt time.Time        `json:"submitted_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+	Deadline    *time.Time       `json:"deadline,omitempty"`
+	ErrorCode   string           `json:"error_code,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Fingerprint    string `json:"fingerprint,omitempty"`
 }
 
-// Task represents a computational task
+// Task represents a computational task. DispatchedAt is when it was last handed
+// to an agent and FinishedAt when it completed, failed or was cancelled.
+// AgentID is the agent holding the lease, LastAgentID the one that reported
+// the result or error, it stays after the lease is released.
 type Task struct {
-	ID            string    `json:"id"`
-	ExpressionID  string    `json:"expression_id"`
-	Arg1          string    `json:"arg1"`
-	Arg2          string    `json:"arg2"`
-	Operation     Operation `json:"operation"`
-	OperationTime int       `json:"operation_time"`
-	Result        *float64  `json:"result,omitempty"`
-	Status        string    `json:"status"`
-	Dependencies  []string  `json:"-"`
+	ID            string      `json:"id"`
+	ExpressionID  string      `json:"expression_id"`
+	Args          []Operand   `json:"args"`
+	Operation     Operation   `json:"operation"`
+	OperationTime int         `json:"operation_time"`
+	Result        *Value      `json:"result,omitempty"`
+	Status        string      `json:"status"`
+	Dependencies  []string    `json:"-"`
+	Mode          NumericMode `json:"mode"`
+	Precision     int         `json:"precision,omitempty"`
+	Priority      int         `json:"priority,omitempty"`
+	Rank          int         `json:"rank"`
+	AgentID       string      `json:"agent_id,omitempty"`
+	LastAgentID   string      `json:"last_agent_id,omitempty"`
+	LeaseID       string      `json:"lease_id,omitempty"`
+	LeaseDeadline time.Time   `json:"lease_deadline,omitempty"`
+	Attempts      int         `json:"attempts"`
+	DispatchedAt  *time.Time  `json:"dispatched_at,omitempty"`
+	FinishedAt    *time.Time  `json:"finished_at,omitempty"`
+}
+
+// Operand is an argument of a task: a literal value or a reference to the task
+// that produces it. Once that task completes the reference is kept and the
+// value is filled in, so agents only ever read Value.
+type Operand struct {
+	Ref   string `json:"ref,omitempty"`
+	Value Value  `json:"value,omitempty"`
+}
+
+// Resolved reports whether the value of the operand is known
+func (o Operand) Resolved() bool {
+	return o.Value != ""
+}
+
+// String returns the value of the operand, or the reference while it is unresolved
+func (o Operand) String() string {
+	if o.Resolved() {
+		return string(o.Value)
+	}
+	return o.Ref
+}
+
+// ExpressionOptions holds per-expression settings, zero values select the service defaults
+// Priority orders expressions for the priority and fair schedulers, higher goes first.
+// An expression that is not completed by its Deadline fails with a timeout.
+type ExpressionOptions struct {
+	Mode     NumericMode
+	Priority int
+	Deadline time.Time
+}
+
+// TaskRequest describes the agent asking for a task. Anonymous agents leave AgentID empty.
+// Operations limits the tasks to those the agent can run, an empty list means any operation.
+// Among the ready tasks the scheduler picks from those with the highest Affinity.
+// A registered agent that does not list operations gets the ones it registered with.
+type TaskRequest struct {
+	AgentID    string
+	Operations []Operation
+	Affinity   map[Operation]int
 }
 
+// operationTiers groups the operations the agent can run by affinity, from the
+// highest to the lowest, operations without an affinity have the weight 0
+func (r TaskRequest) operationTiers() [][]Operation {
+	ops := r.Operations
+	if len(ops) == 0 {
+		ops = SupportedOperations()
+	}
+	byWeight := make(map[int][]Operation)
+	for _, op := range ops {
+		weight := r.Affinity[op]
+		byWeight[weight] = append(byWeight[weight], op)
+	}
+	weights := make([]int, 0, len(byWeight))
+	for weight := range byWeight {
+		weights = append(weights, weight)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(weights)))
+
+	tiers := make([][]Operation, 0, len(weights))
+	for _, weight := range weights {
+		tiers = append(tiers, byWeight[weight])
+	}
+	return tiers
+}
+
+// ErrTaskNotFound is returned for results reported for an unknown task
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrExpressionNotFound is returned for operations on an unknown expression
+var ErrExpressionNotFound = errors.New("expression not found")
+
+// ErrExpressionFinished is returned when cancelling an expression that is already finished
+var ErrExpressionFinished = errors.New("expression already finished")
+
+// ErrStaleLease is returned for results reported under a lease that has expired
+// or for a task that already has a result
+var ErrStaleLease = errors.New("stale task lease")
+
 // OperationTimes holds the configured durations for each operation
 type OperationTimes struct {
 	Addition       int
 	Subtraction    int
 	Multiplication int
 	Division       int
+	Negation       int
+	Exponentiation int
+	Functions      map[string]int
 }
 
 // Service handles the business logic of the calculator
 type Service struct {
-	expressions      map[string]*ExpressionData
-	tasks            map[string]*Task
-	taskQueue        []string
-	completedTasks   map[string]bool
-	readyTasks       map[string]bool
-	opTimes          OperationTimes
-	mu               sync.RWMutex
-	taskIDCounter    int
-	dependencyGraph  map[string][]string
+	expressions         map[string]*ExpressionData
+	tasks               map[string]*Task
+	completedTasks      map[string]bool
+	scheduler           Scheduler
+	opTimes             OperationTimes
+	mu                  sync.RWMutex
+	taskIDCounter       int
+	dependencyGraph     map[string][]string
 	reverseDependencies map[string][]string
+	numericMode         NumericMode
+	precision           int
+	leaseGrace          time.Duration
+	now                 func() time.Time
+	taskReady           chan struct{}
+	agents              *AgentRegistry
+	store               Store
+	changes             changeSet
+	retention           RetentionPolicy
+	gcStats             GCStats
+	events              *EventBus
+	pendingEvents       []Event
+	idempotencyKeys     map[string]idempotencyRecord
+	idempotencyTTL      time.Duration
+	// staged holds the changes waiting to be saved, flushMu lets one flush run at a time
+	staged   commit
+	commitMu sync.Mutex
+	flushMu  sync.Mutex
+}
+
+// Option configures optional settings of a Service
+type Option func(*Service)
+
+// WithNumericMode sets the numeric mode used by expressions that do not choose one,
+// and the number of decimal places kept for inexact results in exact modes
+func WithNumericMode(mode NumericMode, precision int) Option {
+	return func(s *Service) {
+		s.numericMode = mode
+		if precision > 0 {
+			s.precision = precision
+		}
+	}
+}
+
+// WithLeaseGrace sets the extra time an agent gets on top of twice the operation
+// time of a task before its lease expires and the task is handed out again
+func WithLeaseGrace(grace time.Duration) Option {
+	return func(s *Service) {
+		s.leaseGrace = grace
+	}
+}
+
+// WithHeartbeatTimeout sets how long an agent may stay silent before it is considered dead
+func WithHeartbeatTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.agents.timeout = timeout
+	}
+}
+
+// WithAgentRemoveAfter sets how long a dead agent stays in the registry, zero keeps dead agents forever
+func WithAgentRemoveAfter(removeAfter time.Duration) Option {
+	return func(s *Service) {
+		s.agents.removeAfter = removeAfter
+	}
+}
+
+// WithScheduler sets the policy deciding which ready task is handed out next
+func WithScheduler(scheduler Scheduler) Option {
+	return func(s *Service) {
+		s.scheduler = scheduler
+	}
 }
 
 // NewService creates a new calculator service
-func NewService(opTimes OperationTimes) *Service {
-	return &Service{
-		expressions:      make(map[string]*ExpressionData),
-		tasks:            make(map[string]*Task),
-		taskQueue:        []string{},
-		completedTasks:   make(map[string]bool),
-		readyTasks:       make(map[string]bool),
-		opTimes:          opTimes,
-		taskIDCounter:    0,
-		dependencyGraph:  make(map[string][]string),
+func NewService(opTimes OperationTimes, opts ...Option) *Service {
+	s := &Service{
+		expressions:         make(map[string]*ExpressionData),
+		tasks:               make(map[string]*Task),
+		completedTasks:      make(map[string]bool),
+		scheduler:           NewFIFOScheduler(),
+		opTimes:             opTimes,
+		taskIDCounter:       0,
+		dependencyGraph:     make(map[string][]string),
 		reverseDependencies: make(map[string][]string),
+		numericMode:         FloatMode,
+		precision:           DefaultDecimalPrecision,
+		leaseGrace:          DefaultLeaseGrace,
+		now:                 time.Now,
+		taskReady:           make(chan struct{}),
+		agents:              NewAgentRegistry(DefaultHeartbeatTimeout),
+		store:               NopStore{},
+		changes:             changeSet{seen: make(map[string]bool)},
+		events:              NewEventBus(),
+		idempotencyKeys:     make(map[string]idempotencyRecord),
+		idempotencyTTL:      DefaultIdempotencyTTL,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-// SubmitExpression adds a new expression to be calculated
+// SubmitExpression adds a new expression to be calculated with the default options
 func (s *Service) SubmitExpression(expression string) (string, error) {
+	return s.SubmitExpressionWithOptions(expression, ExpressionOptions{})
+}
+
+// SubmitExpressionWithOptions adds a new expression to be calculated
+func (s *Service) SubmitExpressionWithOptions(expression string, opts ExpressionOptions) (string, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
+
+	root, err := parseTree(expression, s.expressionMode(opts))
+	id := s.addExpression(expression, opts, root, err)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
 
-	// Clean the expression by removing spaces
-	expression = strings.ReplaceAll(expression, " ", "")
+// expressionMode returns the numeric mode an expression is calculated in. The default
+// mode is only set on construction, so it may be called without the lock.
+func (s *Service) expressionMode(opts ExpressionOptions) NumericMode {
+	if opts.Mode == "" {
+		return s.numericMode
+	}
+	return opts.Mode
+}
 
-	// Create a new expression entry
+// addExpression stores a new expression and creates the tasks of its syntax tree,
+// an expression with a parse error is stored as failed. The caller must hold the lock.
+func (s *Service) addExpression(expression string, opts ExpressionOptions, root ast.Node, parseErr error) string {
+	// Create a new expression entry, stored without spaces
 	id := uuid.New().String()
 	expr := &ExpressionData{
-		ID:         id,
-		Expression: expression,
-		Status:     Pending,
+		ID:          id,
+		Expression:  strings.ReplaceAll(expression, " ", ""),
+		Status:      Pending,
+		Mode:        s.expressionMode(opts),
+		Priority:    opts.Priority,
+		SubmittedAt: s.now(),
+	}
+	if !opts.Deadline.IsZero() {
+		deadline := opts.Deadline
+		expr.Deadline = &deadline
 	}
 	s.expressions[id] = expr
+	s.changes.expression(id)
 
-	// Parse the expression and create tasks
-	err := s.parseExpression(id, expression)
-	if err != nil {
-		expr.Status = Failed
-		return "", err
+	if parseErr != nil {
+		s.finishExpression(expr, Failed)
+		return id
 	}
+	s.buildTasks(id, root)
 
-	expr.Status = InProcess
-	return id, nil
+	// Expressions without any operation are resolved during parsing
+	if expr.Status == Pending {
+		expr.Status = InProcess
+		s.emit(Event{Type: EventExpressionStatus, ExpressionID: id, Status: InProcess, Mode: expr.Mode})
+	}
+	return id
 }
 
 // GetExpressions returns all expressions
@@ -128,7 +371,7 @@ func (s *Service) GetExpressions() []ExpressionData {
 	return result
 }
 
-// GetExpression returns an expression by its ID
+// GetExpression returns a copy of an expression by its ID
 func (s *Service) GetExpression(id string) (*ExpressionData, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -137,37 +380,122 @@ func (s *Service) GetExpression(id string) (*ExpressionData, bool) {
 	if !ok {
 		return nil, false
 	}
-	return expr, true
+	found := *expr
+	return &found, true
 }
 
-// GetTask returns the next task to be processed
+// GetTask returns the next task to be processed under a new lease.
+// The returned task is a copy, the agent must report its result with the lease ID.
 func (s *Service) GetTask() (*Task, bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
+
+	return s.takeTask(TaskRequest{})
+}
+
+// AssignTask returns the next task like GetTask, leased to the requesting agent
+func (s *Service) AssignTask(req TaskRequest) (*Task, bool) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	return s.takeTask(req)
+}
+
+// WaitForTask returns the next task like AssignTask, waiting for one to become ready
+// until the context is done
+func (s *Service) WaitForTask(ctx context.Context, req TaskRequest) (*Task, bool) {
+	for {
+		s.mu.Lock()
+		task, found := s.takeTask(req)
+		ready := s.taskReady
+		s.unlock()
+		if found {
+			return task, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ready:
+		}
+	}
+}
 
-	// Find a ready task
-	for taskID := range s.readyTasks {
-		task := s.tasks[taskID]
-		task.Status = "processing"
-		delete(s.readyTasks, taskID)
-		return task, true
+// markReady queues a task and wakes up everyone waiting for a task
+func (s *Service) markReady(taskID string) {
+	s.scheduler.Add(s.tasks[taskID])
+	close(s.taskReady)
+	s.taskReady = make(chan struct{})
+}
+
+// takeTask leases a ready task, the caller must hold the lock
+func (s *Service) takeTask(req TaskRequest) (*Task, bool) {
+	// Requests count as heartbeats of registered agents
+	s.agents.touch(req.AgentID, s.now())
+	req = s.agents.capabilities(req)
+
+	// Ask the scheduler for a task the agent can run, preferring higher affinities.
+	// Tasks of expressions that ran out of time are never handed out, their
+	// expression fails instead.
+	now := s.now()
+	var task *Task
+	found := false
+	for _, ops := range req.operationTiers() {
+		for {
+			task, found = s.scheduler.Next(ops)
+			if !found || !s.expireDeadline(s.expressions[task.ExpressionID], now) {
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return nil, false
 	}
 
-	return nil, false
+	task.Status = "processing"
+	s.grantLease(task, req.AgentID)
+	s.changes.task(task.ID)
+	s.emit(Event{Type: EventTaskDispatched, ExpressionID: task.ExpressionID, TaskID: task.ID, Operation: task.Operation, AgentID: task.AgentID})
+
+	leased := *task
+	return &leased, true
 }
 
-// SetTaskResult sets the result of a task
-func (s *Service) SetTaskResult(id string, result float64) error {
+// SetTaskResult sets the result of a task, encoded in the numeric mode of the task.
+// Results from anyone but the current lease holder are rejected with ErrStaleLease.
+func (s *Service) SetTaskResult(id, leaseID string, result Value) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
 
 	task, ok := s.tasks[id]
 	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	}
+
+	// A late result must not complete an expression that already ran out of time
+	s.expireDeadline(s.expressions[task.ExpressionID], s.now())
+	if s.completedTasks[id] {
+		return fmt.Errorf("%w: task %s already has a result", ErrStaleLease, id)
+	}
+	if task.LeaseID == "" || task.LeaseID != leaseID {
+		return fmt.Errorf("%w: task %s is not leased as %s", ErrStaleLease, id, leaseID)
+	}
+	if err := ValidateValue(task.Mode, string(result)); err != nil {
+		return fmt.Errorf("invalid result for task %s: %v", id, err)
 	}
 
 	// Set the result
 	task.Result = &result
+	task.Status = "completed"
+	task.LastAgentID = task.AgentID
+	s.finishTask(task)
+	s.changes.task(id)
+	s.emit(Event{Type: EventTaskCompleted, ExpressionID: task.ExpressionID, TaskID: id, Operation: task.Operation, AgentID: task.AgentID, Result: result, Mode: task.Mode})
+	s.agents.completed(task.AgentID)
+	s.releaseLease(task)
 	s.completedTasks[id] = true
 	
 	// Update the expression if this was the final task
@@ -179,17 +507,106 @@ func (s *Service) SetTaskResult(id string, result float64) error {
 	return nil
 }
 
+// SetTaskError records that the agent holding the lease could not compute a task.
+// The task fails, the remaining tasks of its expression are cancelled and the
+// expression fails with the reported reason.
+func (s *Service) SetTaskError(id, leaseID, code, message string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	}
+	if s.completedTasks[id] {
+		return fmt.Errorf("%w: task %s already has a result", ErrStaleLease, id)
+	}
+	if task.LeaseID == "" || task.LeaseID != leaseID {
+		return fmt.Errorf("%w: task %s is not leased as %s", ErrStaleLease, id, leaseID)
+	}
+
+	task.Status = "failed"
+	task.LastAgentID = task.AgentID
+	s.finishTask(task)
+	s.emit(Event{Type: EventTaskFailed, ExpressionID: task.ExpressionID, TaskID: id, Operation: task.Operation, AgentID: task.AgentID, ErrorCode: code, Reason: message})
+	s.releaseLease(task)
+	s.changes.task(id)
+
+	s.failExpression(task.ExpressionID, code, message)
+	return nil
+}
+
+// CancelExpression stops an expression. Its queued tasks are dropped and results
+// for tasks that agents are still computing are rejected when they arrive.
+func (s *Service) CancelExpression(id string) (*ExpressionData, error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	expr, ok := s.expressions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExpressionNotFound, id)
+	}
+	if expr.Status.Finished() {
+		return nil, fmt.Errorf("%w: %s is %s", ErrExpressionFinished, id, expr.Status)
+	}
+
+	s.cancelTasks(id)
+	expr.Reason = "cancelled by user"
+	s.finishExpression(expr, Cancelled)
+
+	cancelled := *expr
+	return &cancelled, nil
+}
+
+// failExpression cancels every unfinished task of an expression and marks it failed
+func (s *Service) failExpression(exprID, code, reason string) {
+	s.cancelTasks(exprID)
+
+	expr := s.expressions[exprID]
+	expr.ErrorCode = code
+	expr.Reason = reason
+	s.finishExpression(expr, Failed)
+}
+
+// finishExpression sets the final status of an expression and the time it finished
+func (s *Service) finishExpression(expr *ExpressionData, status ExpressionStatus) {
+	finishedAt := s.now()
+	expr.Status = status
+	expr.FinishedAt = &finishedAt
+	s.changes.expression(expr.ID)
+	s.emit(Event{Type: EventExpressionStatus, ExpressionID: expr.ID, Status: status, Result: Value(expr.Value), Mode: expr.Mode, ErrorCode: expr.ErrorCode, Reason: expr.Reason})
+}
+
+// cancelTasks drops the unfinished tasks of an expression from the queue and ends their leases
+func (s *Service) cancelTasks(exprID string) {
+	for taskID, task := range s.tasks {
+		if task.ExpressionID != exprID || s.completedTasks[taskID] || task.Status == "failed" {
+			continue
+		}
+		task.Status = "cancelled"
+		s.finishTask(task)
+		s.releaseLease(task)
+		s.scheduler.Remove(taskID)
+		s.changes.task(taskID)
+	}
+}
+
+// finishTask records when a task completed, failed or was cancelled
+func (s *Service) finishTask(task *Task) {
+	finishedAt := s.now()
+	task.FinishedAt = &finishedAt
+}
+
 // updateDependencies updates the dependent tasks and adds them to the ready queue if all dependencies are met
-func (s *Service) updateDependencies(taskID string, result float64) {
+func (s *Service) updateDependencies(taskID string, result Value) {
 	for _, depID := range s.reverseDependencies[taskID] {
 		depTask := s.tasks[depID]
 		
-		// Update the argument with the result
-		if depTask.Arg1 == taskID {
-			depTask.Arg1 = fmt.Sprintf("%f", result)
-		}
-		if depTask.Arg2 == taskID {
-			depTask.Arg2 = fmt.Sprintf("%f", result)
+		// Resolve the arguments that refer to the completed task
+		for i, arg := range depTask.Args {
+			if arg.Ref == taskID {
+				depTask.Args[i].Value = result
+			}
 		}
 		
 		// Check if all dependencies are completed
@@ -203,7 +620,7 @@ func (s *Service) updateDependencies(taskID string, result float64) {
 		
 		// If all dependencies are completed, add to ready tasks
 		if allDepsCompleted {
-			s.readyTasks[depID] = true
+			s.markReady(depID)
 		}
 	}
 }
@@ -214,7 +631,7 @@ func (s *Service) updateExpressionStatus(exprID string) {
 	
 	// Check if all tasks are completed
 	allCompleted := true
-	var finalResult *float64
+	var finalResult *Value
 	
 	for taskID, task := range s.tasks {
 		if task.ExpressionID == exprID {
@@ -230,81 +647,176 @@ func (s *Service) updateExpressionStatus(exprID string) {
 	}
 	
 	if allCompleted && finalResult != nil {
-		expr.Status = Completed
-		expr.Result = finalResult
+		s.completeExpression(expr, string(*finalResult))
 	}
 }
 
-// parseExpression parses the expression and creates tasks
-func (s *Service) parseExpression(exprID, expression string) error {
-	// We'll implement a simple parsing algorithm for expressions
-	// This parser handles basic operations and respects operator precedence
-	
-	// First, we'll tokenize the expression
-	tokens, err := tokenize(expression)
-	if err != nil {
-		return err
+// completeExpression stores the exact value of an expression and its float approximation
+func (s *Service) completeExpression(expr *ExpressionData, value string) {
+	approx := approximate(value)
+	expr.Value = value
+	expr.Result = &approx
+
+	s.finishExpression(expr, Completed)
+	makespan := expr.FinishedAt.Sub(expr.SubmittedAt).Milliseconds()
+	expr.CompletedAt = expr.FinishedAt
+	expr.Makespan = &makespan
+
+	// Fractions are also shown as decimals rounded to the configured precision
+	if expr.Mode == RationalMode {
+		r, _ := parseRat(value)
+		expr.Decimal = formatDecimal(r, s.precision)
 	}
-	
-	// Apply the shunting yard algorithm to handle operator precedence
-	output, err := shuntingYard(tokens)
+}
+
+// parseTree parses an expression and checks its function calls in the given numeric mode
+func parseTree(expression string, mode NumericMode) (ast.Node, error) {
+	// Parse the expression as submitted, so that error positions match the client's input.
+	// Every syntax error is collected on the way.
+	root, err := ast.Parse(expression)
+	var errs ast.ErrorList
 	if err != nil {
-		return err
+		errs = append(errs, err.(ast.ErrorList)...)
 	}
-	
-	// Create tasks from the postfix notation
-	return s.createTasksFromPostfix(exprID, output)
+
+	// Function names and argument counts are checked against the registry
+	errs = append(errs, checkCalls(root, mode)...)
+	errs = append(errs, checkNumbers(root, mode)...)
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pos < errs[j].Pos })
+		return nil, errs
+	}
+	return root, nil
 }
 
-// createTasksFromPostfix creates tasks from postfix notation
-func (s *Service) createTasksFromPostfix(exprID string, postfix []string) error {
-	var stack []string
-	
-	for _, token := range postfix {
-		if isOperator(token) {
-			// Pop the top two values from the stack
-			if len(stack) < 2 {
-				return fmt.Errorf("invalid expression: not enough operands for operator %s", token)
-			}
-			
-			arg2 := stack[len(stack)-1]
-			arg1 := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
-			
-			// Create a new task
-			taskID := s.createTask(exprID, arg1, arg2, Operation(token))
-			
-			// Push the task ID back onto the stack
-			stack = append(stack, taskID)
-		} else {
-			// Push the operand onto the stack
-			stack = append(stack, token)
-		}
+// buildTasks creates the tasks of an expression from its syntax tree, the caller must hold the lock
+func (s *Service) buildTasks(exprID string, root ast.Node) {
+	expr := s.expressions[exprID]
+
+	// Create tasks by walking the tree
+	first := s.taskIDCounter + 1
+	result := s.createTasks(exprID, root)
+
+	// A bare (possibly negated) number needs no tasks at all
+	if result.Ref == "" {
+		s.completeExpression(expr, s.normalizeLiteral(string(result.Value), expr.Mode))
+		return
 	}
-	
-	// After processing, there should be exactly one item on the stack (the final result)
-	if len(stack) != 1 {
-		return fmt.Errorf("invalid expression: too many values left on stack")
+
+	// Rank the tasks by their remaining path to the root for the critical path scheduler
+	expr.MinMakespan = int64(s.rankTasks(first, s.taskIDCounter))
+
+	// Find tasks with no dependencies and mark them as ready, left to right
+	for i := first; i <= s.taskIDCounter; i++ {
+		taskID := fmt.Sprintf("task_%d", i)
+		if len(s.tasks[taskID].Dependencies) == 0 {
+			s.markReady(taskID)
+		}
 	}
-	
-	// Find tasks with no dependencies and mark them as ready
-	for taskID, task := range s.tasks {
-		if task.ExpressionID == exprID {
-			// If both arguments are not task IDs, this task is ready
-			_, isArg1TaskID := s.tasks[task.Arg1]
-			_, isArg2TaskID := s.tasks[task.Arg2]
-			
-			if !isArg1TaskID && !isArg2TaskID {
-				s.readyTasks[taskID] = true
+}
+
+// checkCalls reports unknown functions, wrong argument counts and functions
+// that are not available in the numeric mode of the expression
+func checkCalls(root ast.Node, mode NumericMode) ast.ErrorList {
+	var errs ast.ErrorList
+	ast.Inspect(root, func(node ast.Node) bool {
+		call, ok := node.(*ast.Call)
+		if !ok {
+			return true
+		}
+		if err := checkArity(call.Name, len(call.Args)); err != nil {
+			errs = append(errs, &ast.Error{Pos: call.Span.Start, Token: call.Name, Message: err.Error()})
+		} else if mode != FloatMode && functions[call.Name].Exact == nil {
+			errs = append(errs, &ast.Error{
+				Pos:     call.Span.Start,
+				Token:   call.Name,
+				Message: fmt.Sprintf("function %s is not supported in %s mode", call.Name, mode),
+			})
+		}
+		return true
+	})
+	return errs
+}
+
+// checkNumbers reports number literals that can not be used in the numeric mode of
+// the expression: exponents beyond maxExactExponent in exact modes, which would take
+// a huge amount of memory, and numbers that overflow float64 in float mode
+func checkNumbers(root ast.Node, mode NumericMode) ast.ErrorList {
+	var errs ast.ErrorList
+	ast.Inspect(root, func(node ast.Node) bool {
+		number, ok := node.(*ast.Number)
+		if !ok {
+			return true
+		}
+		if err := checkLiteral(number.Value, mode); err != nil {
+			errs = append(errs, &ast.Error{Pos: number.Span.Start, Token: number.Value, Message: err.Error()})
+		}
+		return true
+	})
+	return errs
+}
+
+// checkLiteral checks that a number literal can be represented in the numeric mode
+func checkLiteral(literal string, mode NumericMode) error {
+	if mode.IsExact() {
+		if i := strings.IndexAny(literal, "eE"); i >= 0 {
+			exp, err := strconv.Atoi(literal[i+1:])
+			if err != nil || exp > maxExactExponent || exp < -maxExactExponent {
+				return fmt.Errorf("exponent of %s too large", literal)
 			}
 		}
+		return nil
+	}
+	if _, err := strconv.ParseFloat(literal, 64); errors.Is(err, strconv.ErrRange) {
+		return fmt.Errorf("number %s out of range", literal)
 	}
-	
 	return nil
 }
 
-// createTask creates a new task and adds it to the service
-func (s *Service) createTask(exprID, arg1, arg2 string, operation Operation) string {
+// normalizeLiteral writes a literal that is the whole expression the way agents would report it
+func (s *Service) normalizeLiteral(literal string, mode NumericMode) string {
+	if mode == FloatMode {
+		value, _ := strconv.ParseFloat(literal, 64)
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	value, _ := parseRat(literal)
+	return formatExact(value, mode, s.precision)
+}
+
+// createTasks walks the tree bottom-up and creates a task for every operation.
+// It returns the literal value or the task reference that holds the result of the node.
+func (s *Service) createTasks(exprID string, node ast.Node) Operand {
+	switch n := node.(type) {
+	case *ast.Number:
+		return Operand{Value: Value(n.Value)}
+	case *ast.UnaryOp:
+		arg := s.createTasks(exprID, n.Operand)
+		// Unary plus is a no-op
+		if n.Op == "+" {
+			return arg
+		}
+		// Fold negation of a literal locally, there is no need to send it to an agent
+		if arg.Ref == "" {
+			return Operand{Value: Value(negateLiteral(string(arg.Value)))}
+		}
+		return s.createTask(exprID, []Operand{arg}, Negation)
+	case *ast.BinaryOp:
+		left := s.createTasks(exprID, n.Left)
+		right := s.createTasks(exprID, n.Right)
+		return s.createTask(exprID, []Operand{left, right}, Operation(n.Op))
+	case *ast.Call:
+		args := make([]Operand, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = s.createTasks(exprID, arg)
+		}
+		return s.createTask(exprID, args, Operation(n.Name))
+	}
+	// Trees with errors are rejected before task creation
+	panic(fmt.Sprintf("unexpected node %T", node))
+}
+
+// createTask creates a new task and adds it to the service, returning a reference to it
+func (s *Service) createTask(exprID string, args []Operand, operation Operation) Operand {
 	s.taskIDCounter++
 	taskID := fmt.Sprintf("task_%d", s.taskIDCounter)
 	
@@ -319,153 +831,65 @@ func (s *Service) createTask(exprID, arg1, arg2 string, operation Operation) str
 		opTime = s.opTimes.Multiplication
 	case Division:
 		opTime = s.opTimes.Division
+	case Negation:
+		opTime = s.opTimes.Negation
+	case Exponentiation:
+		opTime = s.opTimes.Exponentiation
+	default:
+		opTime = s.opTimes.Functions[string(operation)]
 	}
 	
 	// Create the task
 	task := &Task{
 		ID:            taskID,
 		ExpressionID:  exprID,
-		Arg1:          arg1,
-		Arg2:          arg2,
+		Args:          args,
 		Operation:     operation,
 		OperationTime: opTime,
 		Status:        "pending",
 		Dependencies:  []string{},
+		Mode:          s.expressions[exprID].Mode,
+		Priority:      s.expressions[exprID].Priority,
 	}
-	
-	// Set up dependencies
-	if _, isTaskID := s.tasks[arg1]; isTaskID {
-		task.Dependencies = append(task.Dependencies, arg1)
-		
-		// Add this task to reverse dependencies
-		s.reverseDependencies[arg1] = append(s.reverseDependencies[arg1], taskID)
+	// Rational results are never rounded
+	if task.Mode == DecimalMode {
+		task.Precision = s.precision
 	}
 	
-	if _, isTaskID := s.tasks[arg2]; isTaskID {
-		task.Dependencies = append(task.Dependencies, arg2)
-		
-		// Add this task to reverse dependencies
-		s.reverseDependencies[arg2] = append(s.reverseDependencies[arg2], taskID)
+	// Set up dependencies
+	for _, arg := range args {
+		if arg.Ref != "" {
+			task.Dependencies = append(task.Dependencies, arg.Ref)
+
+			// Add this task to reverse dependencies
+			s.reverseDependencies[arg.Ref] = append(s.reverseDependencies[arg.Ref], taskID)
+		}
 	}
 	
 	s.tasks[taskID] = task
 	s.dependencyGraph[taskID] = task.Dependencies
+	s.changes.task(taskID)
 	
-	return taskID
+	return Operand{Ref: taskID}
 }
 
 // Helper functions
 
-// tokenize converts a string expression into tokens
-func tokenize(expression string) ([]string, error) {
-	var tokens []string
-	var currentNumber string
-	
-	for i := 0; i < len(expression); i++ {
-		char := string(expression[i])
-		
-		switch {
-		case char >= "0" && char <= "9" || char == ".":
-			currentNumber += char
-		case isOperator(char):
-			if currentNumber != "" {
-				tokens = append(tokens, currentNumber)
-				currentNumber = ""
-			}
-			tokens = append(tokens, char)
-		case char == "(" || char == ")":
-			if currentNumber != "" {
-				tokens = append(tokens, currentNumber)
-				currentNumber = ""
-			}
-			tokens = append(tokens, char)
-		default:
-			return nil, fmt.Errorf("invalid character in expression: %s", char)
-		}
-	}
-	
-	if currentNumber != "" {
-		tokens = append(tokens, currentNumber)
+// negateLiteral negates a numeric literal without going through float formatting
+func negateLiteral(token string) string {
+	if strings.HasPrefix(token, "-") {
+		return token[1:]
 	}
-	
-	return tokens, nil
+	return "-" + token
 }
 
-// shuntingYard implements the shunting yard algorithm to convert infix to postfix notation
-func shuntingYard(tokens []string) ([]string, error) {
-	var output []string
-	var operatorStack []string
-	
-	for _, token := range tokens {
-		switch {
-		case isNumber(token):
-			output = append(output, token)
-		case isOperator(token):
-			for len(operatorStack) > 0 && 
-				operatorStack[len(operatorStack)-1] != "(" && 
-				hasHigherPrecedence(operatorStack[len(operatorStack)-1], token) {
-				output = append(output, operatorStack[len(operatorStack)-1])
-				operatorStack = operatorStack[:len(operatorStack)-1]
-			}
-			operatorStack = append(operatorStack, token)
-		case token == "(":
-			operatorStack = append(operatorStack, token)
-		case token == ")":
-			for len(operatorStack) > 0 && operatorStack[len(operatorStack)-1] != "(" {
-				output = append(output, operatorStack[len(operatorStack)-1])
-				operatorStack = operatorStack[:len(operatorStack)-1]
-			}
-			if len(operatorStack) == 0 || operatorStack[len(operatorStack)-1] != "(" {
-				return nil, fmt.Errorf("mismatched parentheses")
-			}
-			operatorStack = operatorStack[:len(operatorStack)-1] // Remove the "("
-		}
-	}
-	
-	for len(operatorStack) > 0 {
-		if operatorStack[len(operatorStack)-1] == "(" {
-			return nil, fmt.Errorf("mismatched parentheses")
-		}
-		output = append(output, operatorStack[len(operatorStack)-1])
-		operatorStack = operatorStack[:len(operatorStack)-1]
-	}
-	
-	return output, nil
-}
-
-// isOperator checks if a token is an operator
-func isOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
+// IsUnary reports whether the operation takes a single argument
+func (op Operation) IsUnary() bool {
+	return op == Negation
 }
 
-// isNumber checks if a token is a number
-func isNumber(token string) bool {
-	_, err := strconv.ParseFloat(token, 64)
-	return err == nil
-}
-
-// getPrecedence returns the precedence of an operator
-func getPrecedence(operator string) int {
-	switch operator {
-	case "+", "-":
-		return 1
-	case "*", "/":
-		return 2
-	default:
-		return 0
-	}
-}
-
-// hasHigherPrecedence checks if op1 has higher or equal precedence than op2
-func hasHigherPrecedence(op1, op2 string) bool {
-	return getPrecedence(op1) >= getPrecedence(op2)
-}
-
-// ProcessOperation performs the arithmetic operation
-func ProcessOperation(operation Operation, arg1, arg2 float64, delay int) (float64, error) {
-	// Simulate long computation
-	time.Sleep(time.Duration(delay) * time.Millisecond)
-	
+// applyOperation performs the arithmetic operation on float64 arguments
+func applyOperation(operation Operation, arg1, arg2 float64) (float64, error) {
 	switch operation {
 	case Addition:
 		return arg1 + arg2, nil
@@ -475,10 +899,30 @@ func ProcessOperation(operation Operation, arg1, arg2 float64, delay int) (float
 		return arg1 * arg2, nil
 	case Division:
 		if arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
+			return 0, ErrDivisionByZero
 		}
 		return arg1 / arg2, nil
+	case Negation:
+		return -arg1, nil
+	case Exponentiation:
+		return power(arg1, arg2)
 	default:
 		return 0, fmt.Errorf("unknown operation: %s", operation)
 	}
-}
\ No newline at end of file
+}
+
+// power raises base to exp. Negative bases are only defined for integer
+// exponents, and results that are not finite are reported as errors.
+func power(base, exp float64) (float64, error) {
+	if base < 0 && exp != math.Trunc(exp) {
+		return 0, fmt.Errorf("fractional power of negative number")
+	}
+	if base == 0 && exp < 0 {
+		return 0, ErrDivisionByZero
+	}
+	result := math.Pow(base, exp)
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, ErrOutOfRange
+	}
+	return result, nil
+}
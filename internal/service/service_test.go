@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -36,6 +38,59 @@ func TestServiceSubmitExpression(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestServiceUnaryOperators(t *testing.T) {
+	// Create a service with minimal operation times for testing
+	svc := NewService(OperationTimes{
+		Addition:       1,
+		Subtraction:    1,
+		Multiplication: 1,
+		Division:       1,
+		Negation:       1,
+	})
+
+	// Leading, nested and repeated signs are all valid
+	for _, expression := range []string{"-5+3", "2*(-3)", "-(1+2)", "2*-3", "+4-+2", "--1+1"} {
+		_, err := svc.SubmitExpression(expression)
+		assert.NoError(t, err, expression)
+	}
+
+	// Binary operators still need operands on both sides
+	_, err := svc.SubmitExpression("2*/3")
+	assert.Error(t, err)
+	_, err = svc.SubmitExpression("-")
+	assert.Error(t, err)
+
+	// Negation of a literal is folded into the operand
+	svc = NewService(OperationTimes{})
+	svc.SubmitExpression("2*-3")
+	task, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, []Operand{{Value: "2"}, {Value: "-3"}}, task.Args)
+
+	// A bare negative number completes without any tasks
+	id, err := svc.SubmitExpression("-7")
+	assert.NoError(t, err)
+	expr, _ := svc.GetExpression(id)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, -7.0, *expr.Result)
+
+	// Negation of a subexpression is scheduled as its own task
+	id, _ = svc.SubmitExpression("-(1+2)")
+	task, found = svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Addition, task.Operation)
+	assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, "3"))
+
+	task, found = svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Negation, task.Operation)
+	assert.NoError(t, svc.SetTaskResult(task.ID, task.LeaseID, "-3"))
+
+	expr, _ = svc.GetExpression(id)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, -3.0, *expr.Result)
+}
+
 func TestServiceGetExpressions(t *testing.T) {
 	// Create a service with minimal operation times for testing
 	svc := NewService(OperationTimes{
@@ -108,8 +163,7 @@ func TestServiceGetTask(t *testing.T) {
 	task, found := svc.GetTask()
 	assert.True(t, found)
 	assert.NotNil(t, task)
-	assert.Equal(t, "2", task.Arg1)
-	assert.Equal(t, "2", task.Arg2)
+	assert.Equal(t, []Operand{{Value: "2"}, {Value: "2"}}, task.Args)
 	assert.Equal(t, Addition, task.Operation)
 }
 
@@ -130,7 +184,7 @@ func TestServiceSetTaskResult(t *testing.T) {
 	assert.True(t, found)
 
 	// Set the result
-	err := svc.SetTaskResult(task.ID, 4)
+	err := svc.SetTaskResult(task.ID, task.LeaseID, "4")
 	assert.NoError(t, err)
 
 	// Verify the expression is updated
@@ -141,32 +195,184 @@ func TestServiceSetTaskResult(t *testing.T) {
 	assert.Equal(t, 4.0, *expr.Result)
 }
 
-func TestProcessOperation(t *testing.T) {
+func TestProcessTaskOperation(t *testing.T) {
 	// Test addition
-	result, err := ProcessOperation(Addition, 2, 3, 1)
+	result, err := ProcessTask(&Task{Operation: Addition, Args: literals("2", "3")})
 	assert.NoError(t, err)
-	assert.Equal(t, 5.0, result)
+	assert.Equal(t, Value("5"), result)
 
 	// Test subtraction
-	result, err = ProcessOperation(Subtraction, 5, 3, 1)
+	result, err = ProcessTask(&Task{Operation: Subtraction, Args: literals("5", "3")})
 	assert.NoError(t, err)
-	assert.Equal(t, 2.0, result)
+	assert.Equal(t, Value("2"), result)
 
 	// Test multiplication
-	result, err = ProcessOperation(Multiplication, 2, 3, 1)
+	result, err = ProcessTask(&Task{Operation: Multiplication, Args: literals("2", "3")})
 	assert.NoError(t, err)
-	assert.Equal(t, 6.0, result)
+	assert.Equal(t, Value("6"), result)
 
 	// Test division
-	result, err = ProcessOperation(Division, 6, 3, 1)
+	result, err = ProcessTask(&Task{Operation: Division, Args: literals("6", "3")})
 	assert.NoError(t, err)
-	assert.Equal(t, 2.0, result)
+	assert.Equal(t, Value("2"), result)
 
 	// Test division by zero
-	_, err = ProcessOperation(Division, 6, 0, 1)
+	_, err = ProcessTask(&Task{Operation: Division, Args: literals("6", "0")})
+	assert.Error(t, err)
+
+	// Test negation
+	result, err = ProcessTask(&Task{Operation: Negation, Args: literals("4")})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("-4"), result)
+
+	// Test exponentiation
+	result, err = ProcessTask(&Task{Operation: Exponentiation, Args: literals("2", "10")})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("1024"), result)
+
+	result, err = ProcessTask(&Task{Operation: Exponentiation, Args: literals("-2", "3")})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("-8"), result)
+
+	result, err = ProcessTask(&Task{Operation: Exponentiation, Args: literals("4", "0.5")})
+	assert.NoError(t, err)
+	assert.Equal(t, Value("2"), result)
+
+	// Negative base with a fractional exponent is undefined
+	_, err = ProcessTask(&Task{Operation: Exponentiation, Args: literals("-4", "0.5")})
+	assert.Error(t, err)
+
+	// Zero to a negative power is a division by zero
+	_, err = ProcessTask(&Task{Operation: Exponentiation, Args: literals("0", "-1")})
 	assert.Error(t, err)
 
 	// Test unknown operation
-	_, err = ProcessOperation("unknown", 2, 3, 1)
+	_, err = ProcessTask(&Task{Operation: "unknown", Args: literals("2", "3")})
 	assert.Error(t, err)
-}
\ No newline at end of file
+}
+
+func TestServiceSetTaskError(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	exprID, _ := svc.SubmitExpression("1/0+2*3")
+
+	// Both operands are handed out to agents
+	task, _ := svc.GetTask()
+	sibling, _ := svc.GetTask()
+	if task.Operation != Division {
+		task, sibling = sibling, task
+	}
+
+	_, err := ProcessTask(task)
+	assert.ErrorIs(t, err, ErrDivisionByZero)
+	assert.NoError(t, svc.SetTaskError(task.ID, task.LeaseID, FailureCode(err), err.Error()))
+
+	// The sibling and dependent tasks are cancelled
+	assert.ErrorIs(t, svc.SetTaskResult(sibling.ID, sibling.LeaseID, "6"), ErrStaleLease)
+	_, found := svc.GetTask()
+	assert.False(t, found)
+
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, Failed, expr.Status)
+	assert.Equal(t, FailureDivisionByZero, expr.ErrorCode)
+	assert.Equal(t, "division by zero", expr.Reason)
+	assert.Nil(t, expr.Result)
+
+	// Reports for tasks of a failed expression are rejected
+	assert.ErrorIs(t, svc.SetTaskError(task.ID, task.LeaseID, FailureComputation, "again"), ErrStaleLease)
+	assert.ErrorIs(t, svc.SetTaskError("task_0", "lease", FailureComputation, "unknown"), ErrTaskNotFound)
+}
+
+func TestServiceWaitForTask(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	// Nothing is ready, the wait ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, found := svc.WaitForTask(ctx, TaskRequest{})
+	assert.False(t, found)
+
+	// A waiting agent is woken up by a new expression
+	done := make(chan *Task)
+	go func() {
+		task, _ := svc.WaitForTask(context.Background(), TaskRequest{})
+		done <- task
+	}()
+	time.Sleep(10 * time.Millisecond)
+	svc.SubmitExpression("2+2")
+
+	select {
+	case task := <-done:
+		assert.Equal(t, Addition, task.Operation)
+	case <-time.After(time.Second):
+		t.Fatal("waiting agent was not woken up")
+	}
+}
+
+func TestServiceCancelExpression(t *testing.T) {
+	svc := NewService(OperationTimes{})
+
+	exprID, _ := svc.SubmitExpression("1+2+3*4")
+	task, _ := svc.GetTask()
+
+	expr, err := svc.CancelExpression(exprID)
+	assert.NoError(t, err)
+	assert.Equal(t, Cancelled, expr.Status)
+
+	// Queued tasks are gone and the late result of the agent is discarded
+	_, found := svc.GetTask()
+	assert.False(t, found)
+	assert.ErrorIs(t, svc.SetTaskResult(task.ID, task.LeaseID, "3"), ErrStaleLease)
+
+	stored, _ := svc.GetExpression(exprID)
+	assert.Equal(t, Cancelled, stored.Status)
+	assert.Nil(t, stored.Result)
+
+	_, err = svc.CancelExpression(exprID)
+	assert.ErrorIs(t, err, ErrExpressionFinished)
+	_, err = svc.CancelExpression("unknown")
+	assert.ErrorIs(t, err, ErrExpressionNotFound)
+}
+
+func TestServiceExpireDeadlines(t *testing.T) {
+	now := time.Now()
+	svc := NewService(OperationTimes{})
+	svc.now = func() time.Time { return now }
+
+	exprID, _ := svc.SubmitExpressionWithOptions("1+2+3*4", ExpressionOptions{Deadline: now.Add(time.Second)})
+	otherID, _ := svc.SubmitExpression("5+6")
+	task, _ := svc.GetTask()
+
+	// Nothing expires before the deadline
+	assert.Equal(t, 0, svc.ExpireDeadlines())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, svc.ExpireDeadlines())
+
+	expr, _ := svc.GetExpression(exprID)
+	assert.Equal(t, Failed, expr.Status)
+	assert.Equal(t, FailureTimeout, expr.ErrorCode)
+	assert.Contains(t, expr.Reason, "timeout")
+
+	// The remaining tasks are not scheduled and the late result is discarded
+	assert.ErrorIs(t, svc.SetTaskResult(task.ID, task.LeaseID, "3"), ErrStaleLease)
+	next, found := svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, otherID, next.ExpressionID)
+	_, found = svc.GetTask()
+	assert.False(t, found)
+
+	// Expressions without a deadline never expire
+	assert.Equal(t, 0, svc.ExpireDeadlines())
+
+	// Without a sweep, dispatch fails the expression of a task that is past its deadline
+	lateID, _ := svc.SubmitExpressionWithOptions("7+8", ExpressionOptions{Deadline: now.Add(time.Second)})
+	laterID, _ := svc.SubmitExpression("9+10")
+	now = now.Add(time.Second)
+	next, found = svc.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, laterID, next.ExpressionID)
+	expr, _ = svc.GetExpression(lateID)
+	assert.Equal(t, Failed, expr.Status)
+	assert.Equal(t, FailureTimeout, expr.ErrorCode)
+}
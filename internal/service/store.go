@@ -0,0 +1,306 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Store keeps expressions and tasks across restarts of the orchestrator.
+// The service keeps working on its own maps and takes a copy of every
+// expression and task it changed, and the IDs of those it removed, before it
+// releases its lock. The store gets them after the lock is released.
+type Store interface {
+	// Save stores the current state of the given expressions and tasks
+	Save(expressions []ExpressionData, tasks []Task) error
+	// Delete removes the expressions and tasks with the given IDs
+	Delete(expressionIDs, taskIDs []string) error
+	// Load returns the last saved state of every expression and task
+	Load() ([]ExpressionData, []Task, error)
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// NopStore keeps nothing, it is the store of a service that does not persist its state
+type NopStore struct{}
+
+// Save does nothing
+func (NopStore) Save(expressions []ExpressionData, tasks []Task) error { return nil }
+
+// Delete does nothing
+func (NopStore) Delete(expressionIDs, taskIDs []string) error { return nil }
+
+// Load returns an empty state
+func (NopStore) Load() ([]ExpressionData, []Task, error) { return nil, nil, nil }
+
+// Close does nothing
+func (NopStore) Close() error { return nil }
+
+// WithStore sets the store the service saves its state to, call Recover to load it back
+func WithStore(store Store) Option {
+	return func(s *Service) {
+		s.store = store
+	}
+}
+
+// changeSet collects the expressions and tasks changed or removed while the lock is held
+type changeSet struct {
+	expressions []string
+	tasks       []string
+	seen        map[string]bool
+}
+
+// expression records a change or the removal of an expression
+func (c *changeSet) expression(id string) {
+	if !c.seen["expression:"+id] {
+		c.seen["expression:"+id] = true
+		c.expressions = append(c.expressions, id)
+	}
+}
+
+// task records a change or the removal of a task
+func (c *changeSet) task(id string) {
+	if !c.seen["task:"+id] {
+		c.seen["task:"+id] = true
+		c.tasks = append(c.tasks, id)
+	}
+}
+
+// reset forgets every recorded change
+func (c *changeSet) reset() {
+	c.expressions = nil
+	c.tasks = nil
+	c.seen = make(map[string]bool)
+}
+
+// commit holds the changes of critical sections whose lock was released
+// but that are not saved yet, oldest first
+type commit struct {
+	expressions        []ExpressionData
+	tasks              []Task
+	removedExpressions []string
+	removedTasks       []string
+	events             []Event
+}
+
+// unlock releases the lock, then saves the changes made under it to the store
+// and publishes their events. Writing and syncing the store does not hold up
+// the service, and unlock returns once its own changes are saved.
+func (s *Service) unlock() {
+	s.stage()
+	s.mu.Unlock()
+	s.flush()
+}
+
+// stage copies the changed expressions and tasks, the IDs of the removed ones
+// and the queued events to the commit, the caller must hold the lock. Staging
+// under the lock keeps the changes in the order they were made.
+func (s *Service) stage() {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	s.staged.events = append(s.staged.events, s.pendingEvents...)
+	s.pendingEvents = nil
+
+	// Without persistence there is nothing to copy
+	if _, ok := s.store.(NopStore); ok {
+		s.changes.reset()
+		return
+	}
+
+	for _, id := range s.changes.expressions {
+		if expr, ok := s.expressions[id]; ok {
+			s.staged.expressions = append(s.staged.expressions, *expr)
+		} else {
+			s.staged.removedExpressions = append(s.staged.removedExpressions, id)
+		}
+	}
+	for _, id := range s.changes.tasks {
+		if task, ok := s.tasks[id]; ok {
+			s.staged.tasks = append(s.staged.tasks, copyTask(task))
+		} else {
+			s.staged.removedTasks = append(s.staged.removedTasks, id)
+		}
+	}
+	s.changes.reset()
+}
+
+// flush saves everything staged so far and then publishes its events. The
+// changes of critical sections that ended while an earlier flush was syncing
+// are saved together, with one sync. A failing store does not undo the change,
+// the error is only logged.
+func (s *Service) flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.commitMu.Lock()
+	c := s.staged
+	s.staged = commit{}
+	s.commitMu.Unlock()
+
+	// Nothing is ever saved again once it is removed, so the removals can go last
+	if len(c.expressions) > 0 || len(c.tasks) > 0 {
+		if err := s.store.Save(c.expressions, c.tasks); err != nil {
+			log.Printf("Error saving %d expressions and %d tasks: %v", len(c.expressions), len(c.tasks), err)
+		}
+	}
+	if len(c.removedExpressions) > 0 || len(c.removedTasks) > 0 {
+		if err := s.store.Delete(c.removedExpressions, c.removedTasks); err != nil {
+			log.Printf("Error deleting %d expressions and %d tasks: %v", len(c.removedExpressions), len(c.removedTasks), err)
+		}
+	}
+	s.publish(c.events)
+}
+
+// Recover loads the expressions and tasks saved in the store into a new service.
+// The dependency graph is rebuilt, the arguments of tasks are resolved from the
+// results of their dependencies and every unfinished task of an unfinished
+// expression goes back to the queue, including tasks that agents were computing.
+func (s *Service) Recover() error {
+	expressions, tasks, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("loading store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	for i := range expressions {
+		expr := expressions[i]
+		s.expressions[expr.ID] = &expr
+		s.restoreIdempotencyKey(&expr)
+	}
+
+	// Tasks are restored in creation order, so the queue keeps its order
+	sort.Slice(tasks, func(i, j int) bool { return taskNumber(tasks[i].ID) < taskNumber(tasks[j].ID) })
+	for i := range tasks {
+		task := copyTask(&tasks[i])
+		s.tasks[task.ID] = &task
+		s.dependencyGraph[task.ID] = task.Dependencies
+		for _, depID := range task.Dependencies {
+			s.reverseDependencies[depID] = append(s.reverseDependencies[depID], task.ID)
+		}
+		if task.Status == "completed" {
+			s.completedTasks[task.ID] = true
+		}
+		if n := taskNumber(task.ID); n > s.taskIDCounter {
+			s.taskIDCounter = n
+		}
+	}
+
+	requeued := 0
+	for i := range tasks {
+		task := s.tasks[tasks[i].ID]
+		for j, arg := range task.Args {
+			if dep, ok := s.tasks[arg.Ref]; ok && dep.Result != nil {
+				task.Args[j].Value = *dep.Result
+			}
+		}
+
+		expr, ok := s.expressions[task.ExpressionID]
+		if !ok || expr.Status.Finished() || (task.Status != "pending" && task.Status != "processing") {
+			continue
+		}
+
+		// Leases do not survive a restart, the task is handed out again
+		task.Status = "pending"
+		s.releaseLease(task)
+		s.changes.task(task.ID)
+
+		ready := true
+		for _, depID := range task.Dependencies {
+			if !s.completedTasks[depID] {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			s.markReady(task.ID)
+			requeued++
+		}
+	}
+
+	log.Printf("Recovered %d expressions and %d tasks, %d tasks requeued", len(expressions), len(tasks), requeued)
+	return nil
+}
+
+// taskNumber returns the counter value a task ID was created with
+func taskNumber(id string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(id, "task_"))
+	return n
+}
+
+// copyTask copies a task, including its arguments which are resolved in place
+func copyTask(task *Task) Task {
+	c := *task
+	c.Args = append([]Operand(nil), task.Args...)
+	return c
+}
+
+// MemoryStore keeps the saved state in memory. It does not survive a restart
+// of the process but lets a new service recover the state of an old one.
+type MemoryStore struct {
+	mu          sync.Mutex
+	expressions map[string]ExpressionData
+	tasks       map[string]Task
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		expressions: make(map[string]ExpressionData),
+		tasks:       make(map[string]Task),
+	}
+}
+
+// Save stores copies of the expressions and tasks
+func (m *MemoryStore) Save(expressions []ExpressionData, tasks []Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, expr := range expressions {
+		m.expressions[expr.ID] = expr
+	}
+	for i := range tasks {
+		m.tasks[tasks[i].ID] = copyTask(&tasks[i])
+	}
+	return nil
+}
+
+// Delete removes the expressions and tasks
+func (m *MemoryStore) Delete(expressionIDs, taskIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range expressionIDs {
+		delete(m.expressions, id)
+	}
+	for _, id := range taskIDs {
+		delete(m.tasks, id)
+	}
+	return nil
+}
+
+// Load returns copies of every stored expression and task
+func (m *MemoryStore) Load() ([]ExpressionData, []Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expressions := make([]ExpressionData, 0, len(m.expressions))
+	for _, expr := range m.expressions {
+		expressions = append(expressions, expr)
+	}
+	tasks := make([]Task, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		tasks = append(tasks, copyTask(&task))
+	}
+	return expressions, tasks, nil
+}
+
+// Close does nothing for the in-memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceRecover(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewService(OperationTimes{}, WithStore(store))
+
+	exprID, _ := svc.SubmitExpression("(1+2)*(3+4)")
+	doneID, _ := svc.SubmitExpression("5")
+	cancelledID, _ := svc.SubmitExpression("6+7")
+	svc.CancelExpression(cancelledID)
+
+	// One task completes, the other one is still with an agent when the orchestrator stops
+	first, _ := svc.GetTask()
+	assert.NoError(t, svc.SetTaskResult(first.ID, first.LeaseID, "3"))
+	inFlight, _ := svc.GetTask()
+	assert.Equal(t, "3+4", string(inFlight.Args[0].Value)+"+"+string(inFlight.Args[1].Value))
+
+	restarted := NewService(OperationTimes{}, WithStore(store))
+	assert.NoError(t, restarted.Recover())
+
+	done, found := restarted.GetExpression(doneID)
+	assert.True(t, found)
+	assert.Equal(t, Completed, done.Status)
+	cancelled, _ := restarted.GetExpression(cancelledID)
+	assert.Equal(t, Cancelled, cancelled.Status)
+
+	// The task of the lost lease is handed out again and the old lease is rejected
+	task, found := restarted.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, inFlight.ID, task.ID)
+	assert.NotEqual(t, inFlight.LeaseID, task.LeaseID)
+	assert.ErrorIs(t, restarted.SetTaskResult(inFlight.ID, inFlight.LeaseID, "7"), ErrStaleLease)
+	assert.NoError(t, restarted.SetTaskResult(task.ID, task.LeaseID, "7"))
+
+	// The root task gets the result recovered from before the restart
+	root, found := restarted.GetTask()
+	assert.True(t, found)
+	assert.Equal(t, Value("3"), root.Args[0].Value)
+	assert.Equal(t, Value("7"), root.Args[1].Value)
+	assert.NoError(t, restarted.SetTaskResult(root.ID, root.LeaseID, "21"))
+
+	expr, _ := restarted.GetExpression(exprID)
+	assert.Equal(t, Completed, expr.Status)
+	assert.Equal(t, 21.0, *expr.Result)
+
+	// New tasks do not reuse recovered IDs
+	restarted.SubmitExpression("1+1")
+	next, _ := restarted.GetTask()
+	assert.Greater(t, taskNumber(next.ID), taskNumber(root.ID))
+}
+
+// blockingStore is a memory store whose saves wait until release is closed
+type blockingStore struct {
+	*MemoryStore
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStore) Save(expressions []ExpressionData, tasks []Task) error {
+	b.saving <- struct{}{}
+	<-b.release
+	return b.MemoryStore.Save(expressions, tasks)
+}
+
+func TestServiceSavesOutsideLock(t *testing.T) {
+	store := &blockingStore{MemoryStore: NewMemoryStore(), saving: make(chan struct{}, 1), release: make(chan struct{})}
+	svc := NewService(OperationTimes{}, WithStore(store))
+	sub := svc.Events().Subscribe("", 10)
+	defer sub.Close()
+
+	submitted := make(chan string)
+	go func() {
+		id, _ := svc.SubmitExpression("1+1")
+		submitted <- id
+	}()
+	<-store.saving
+
+	// The service answers while the store syncs, the event waits for the save
+	done := make(chan struct{})
+	go func() {
+		svc.GetExpressions()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the service is locked while the store saves")
+	}
+	assert.Empty(t, sub.Events())
+
+	close(store.release)
+	exprID := <-submitted
+	event := <-sub.Events()
+	assert.Equal(t, exprID, event.ExpressionID)
+	_, tasks, _ := store.Load()
+	assert.Len(t, tasks, 1)
+}
@@ -9,6 +9,29 @@ type AppError struct {
 	Code    int
 	Message string
 	Err     error
+	Kind    string
+	Details []Detail
+}
+
+// Detail describes a single problem with a request, such as a syntax error in an expression
+type Detail struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Position int    `json:"position"`
+	Token    string `json:"token,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// ErrorResponse is the JSON body returned to clients for an AppError.
+// The top level fields describe the first detail, if there is one.
+type ErrorResponse struct {
+	Error    string   `json:"error"`
+	Code     string   `json:"code,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Position *int     `json:"position,omitempty"`
+	Hint     string   `json:"hint,omitempty"`
+	Details  []Detail `json:"details,omitempty"`
 }
 
 // Error returns the error message
@@ -24,6 +47,23 @@ func (e AppError) Unwrap() error {
 	return e.Err
 }
 
+// Response returns the JSON body for the error
+func (e AppError) Response() ErrorResponse {
+	resp := ErrorResponse{
+		Error:   e.Error(),
+		Code:    e.Kind,
+		Message: e.Message,
+		Details: e.Details,
+	}
+	if len(e.Details) > 0 {
+		first := e.Details[0]
+		resp.Message = first.Message
+		resp.Position = &first.Position
+		resp.Hint = first.Hint
+	}
+	return resp
+}
+
 // NewAppError creates a new application error
 func NewAppError(code int, message string, err error) AppError {
 	return AppError{
@@ -46,4 +86,16 @@ func NewNotFoundError(message string, err error) AppError {
 // NewInternalServerError creates a new internal server error
 func NewInternalServerError(message string, err error) AppError {
 	return NewAppError(500, message, err)
+}
+
+// NewUnprocessableEntityError creates a new unprocessable entity error
+func NewUnprocessableEntityError(message string, err error) AppError {
+	return NewAppError(422, message, err)
+}
+
+// WithDetails returns a copy of the error with a machine readable kind and details
+func (e AppError) WithDetails(kind string, details []Detail) AppError {
+	e.Kind = kind
+	e.Details = details
+	return e
 }
\ No newline at end of file
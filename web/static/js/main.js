@@ -39,24 +39,56 @@ document.addEventListener("DOMContentLoaded", () => {
         }
     }
 
+    // Rows of the list by expression ID, so that an event only redraws its own row
+    const rows = new Map();
+
+    function renderRow(item, exp) {
+        // The exact value is shown when there is one, the result is a float64 approximation
+        const result = exp.value ?? exp.result ?? "Pending";
+        const fields = [["ID", exp.id], ["Status", exp.status], ["Result", result]];
+        if (exp.reason) {
+            fields.push(["Reason", exp.reason]);
+        }
+
+        // The values come from the server and may contain markup, they are set as text
+        const paragraph = document.createElement("p");
+        fields.forEach(([label, value], i) => {
+            if (i > 0) {
+                paragraph.appendChild(document.createElement("br"));
+            }
+            const name = document.createElement("strong");
+            name.textContent = `${label}:`;
+            paragraph.append(name, ` ${value} `);
+        });
+        item.replaceChildren(paragraph);
+    }
+
+    function updateRow(exp) {
+        let item = rows.get(exp.id);
+        if (!item) {
+            if (rows.size === 0) {
+                expressionsList.innerHTML = "";
+            }
+            item = document.createElement("div");
+            item.classList.add("expression-item");
+            rows.set(exp.id, item);
+            expressionsList.appendChild(item);
+        }
+        renderRow(item, exp);
+    }
+
     async function updateExpressionsList() {
         loading.style.display = "block";
-        expressionsList.innerHTML = "";
-
         const expressions = await fetchExpressions();
         loading.style.display = "none";
 
+        expressionsList.innerHTML = "";
+        rows.clear();
         if (expressions.length === 0) {
             expressionsList.innerHTML = "<p>No expressions available.</p>";
             return;
         }
-
-        expressions.forEach(exp => {
-            const item = document.createElement("div");
-            item.classList.add("expression-item");
-            item.innerHTML = `<p><strong>ID:</strong> ${exp.id} <br> <strong>Status:</strong> ${exp.status} <br> <strong>Result:</strong> ${exp.result !== null ? exp.result : "Pending"}</p>`;
-            expressionsList.appendChild(item);
-        });
+        expressions.forEach(updateRow);
     }
 
     calculateButton.addEventListener("click", async () => {
@@ -66,10 +98,38 @@ document.addEventListener("DOMContentLoaded", () => {
         const id = await calculateExpression(expression);
         if (id) {
             expressionInput.value = "";
-            setTimeout(updateExpressionsList, 2000); // Delay to allow processing
         }
     });
 
     updateExpressionsList();
-    setInterval(updateExpressionsList, 5000); // Refresh expressions list every 5 sec
+
+    // Status events carry everything a row shows, the list is not fetched again for them.
+    // While the stream is down the list is polled slowly, and fetched once when it is back
+    // to pick up the events that were missed.
+    const pollInterval = 30000;
+    let poller = null;
+
+    const events = new EventSource("/api/v1/events");
+    events.addEventListener("expression_status", (message) => {
+        const event = JSON.parse(message.data);
+        updateRow({
+            id: event.expression_id,
+            status: event.status,
+            value: event.result !== undefined ? String(event.result) : undefined,
+            reason: event.reason,
+        });
+    });
+    events.onopen = () => {
+        if (poller !== null) {
+            clearInterval(poller);
+            poller = null;
+            updateExpressionsList();
+        }
+    };
+    events.onerror = () => {
+        if (poller === null) {
+            console.error(`Event stream interrupted, polling every ${pollInterval / 1000} seconds until it is back`);
+            poller = setInterval(updateExpressionsList, pollInterval);
+        }
+    };
 });